@@ -1,12 +1,16 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"image"
+	"mime/multipart"
 	"net/http"
 	"strconv"
 
 	engine "github.com/jx3yang/imgsearchengine/src/engine"
+	index "github.com/jx3yang/imgsearchengine/src/index"
 	phash "github.com/jx3yang/imgsearchengine/src/phash"
 	vptree "github.com/jx3yang/imgsearchengine/src/vptree"
 )
@@ -14,6 +18,12 @@ import (
 const contentTypeKey = "Content-Type"
 const defaultContentType = "application/json"
 
+// partialResultsKey is the header set when a search stopped early
+const partialResultsKey = "X-Partial-Results"
+
+// maxBatchSize is the maximum number of queries of a batch search
+const maxBatchSize = 1000
+
 type queryResult struct {
 	path     string
 	distance float64
@@ -21,119 +31,574 @@ type queryResult struct {
 
 // EngineAPI serves the image searching engine
 type EngineAPI struct {
-	Tree *vptree.VPTree
+	// Index answers the queries, e.g. a vptree.VPTree or a mih.MIH
+	Index index.SearchIndex[*engine.ImageInfo]
+	// Budget bounds the work done by each search of the indexes
+	// supporting it, the zero value means no limit
+	Budget vptree.SearchOptions
+	// BatchWorkers is the number of queries of a batch searched
+	// concurrently, it defaults to GOMAXPROCS
+	BatchWorkers int
+	// MaxUploadSize is the size limit of the search requests, in bytes,
+	// including the uploaded images. It defaults to 10MB.
+	MaxUploadSize int64
+	// Fetcher downloads the images given by URL, the default
+	// one only reaches public addresses
+	Fetcher *Fetcher
+	// Hasher computes the PHashes of the query and inserted images, it must
+	// be the algorithm of the indexed PHashes, and defaults to phash.GetPHash
+	Hasher phash.Hasher
+	// Algorithm is the algorithm of the indexed hashes, recorded when loading
+	// them, see engine.Algorithm. The requests are rejected when the Hasher,
+	// or the extended hashes of HashBits, are of another algorithm.
+	Algorithm phash.Algorithm
+	// HashBits is the length of the extended hashes of the indexed images,
+	// see phash.GetExtPHash, 0 means the PHashes computed by Hasher
+	HashBits int
+	// CropResistant also hashes the regions of the query and inserted
+	// images, see phash.CropResistantHashes, for the indexes comparing the
+	// images with engine.RegionDistance. It is ignored with HashBits.
+	CropResistant bool
+}
+
+// budgetedIndex is implemented by the indexes whose searches
+// can be bounded and cancelled, such as vptree.VPTree
+type budgetedIndex interface {
+	KNNSearchContext(ctx context.Context, point *engine.ImageInfo, k uint, opts vptree.SearchOptions) ([]index.Result[*engine.ImageInfo], bool, error)
+	RangeSearchContext(ctx context.Context, point *engine.ImageInfo, threshold float64, opts vptree.SearchOptions) ([]index.Result[*engine.ImageInfo], bool, error)
+}
+
+func (service *EngineAPI) fetchImage(ctx context.Context, imageURL string) (image.Image, error) {
+	if service.Fetcher != nil {
+		return service.Fetcher.Fetch(ctx, imageURL)
+	}
+	return defaultFetcher.Fetch(ctx, imageURL)
+}
+
+func (service *EngineAPI) hasher() phash.Hasher {
+	if service.Hasher != nil {
+		return service.Hasher
+	}
+	return phash.PerceptionHasher{}
+}
+
+// checkAlgorithm fails when the hashes of the requests are computed by
+// another algorithm than the indexed hashes, which they cannot be compared to
+func (service *EngineAPI) checkAlgorithm() *apiError {
+	algorithm := service.hasher().Algorithm()
+	if service.HashBits > 0 {
+		// the extended hashes are Perception Hashes, see engine.NewExtImageInfo
+		algorithm = phash.Perception
+	}
+	if algorithm != service.Algorithm {
+		return algorithmError(service.Algorithm, algorithm)
+	}
+	return nil
+}
+
+// imageInfo hashes `img` into the ImageInfo of the image at `path`,
+// the errors name the path if any
+func (service *EngineAPI) imageInfo(img image.Image, path string) (*engine.ImageInfo, error) {
+	if service.HashBits > 0 {
+		ext, err := phash.GetExtPHash(img, service.HashBits)
+		if err != nil {
+			return nil, pathError("hash", path, err)
+		}
+		return engine.NewExtImageInfo(ext, path), nil
+	}
+	hasher := service.hasher()
+	if service.CropResistant {
+		hashes, err := phash.CropResistantHashes(img, hasher)
+		if err != nil {
+			return nil, pathError("hash", path, err)
+		}
+		return engine.NewRegionImageInfo(hashes, hasher.Algorithm(), path), nil
+	}
+	hash, err := hasher.Hash(img)
+	if err != nil {
+		return nil, pathError("hash", path, err)
+	}
+	return engine.NewImageInfoWithAlgorithm(hash, hasher.Algorithm(), path), nil
+}
+
+// points returns the ImageInfo of `img`, or the ImageInfos of its 8
+// transforms if `dihedral` is set, see phash.Transforms
+func (service *EngineAPI) points(img image.Image, path string, dihedral bool) ([]*engine.ImageInfo, error) {
+	if !dihedral {
+		point, err := service.imageInfo(img, path)
+		if err != nil {
+			return nil, err
+		}
+		return []*engine.ImageInfo{point}, nil
+	}
+
+	points := make([]*engine.ImageInfo, len(phash.Transforms))
+	for i, t := range phash.Transforms {
+		point, err := service.imageInfo(phash.Transformed(img, t), path)
+		if err != nil {
+			return nil, err
+		}
+		points[i] = point
+	}
+	return points, nil
+}
+
+// pathError reports that the image at `path` failed the `action`
+func pathError(action string, path string, err error) error {
+	if path == "" {
+		return err
+	}
+	return fmt.Errorf("Unable to %s %s: %w", action, path, err)
 }
 
-func (service *EngineAPI) invalidTree() bool {
-	return service.Tree == nil || service.Tree.Root == nil
+func (service *EngineAPI) ready() bool {
+	return service.Index != nil && service.Index.Ready()
 }
 
 // Ping will check if the engine is ready
 func (service *EngineAPI) Ping(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set(contentTypeKey, defaultContentType)
 
-	result := make(map[string]bool)
+	result := make(map[string]interface{})
 
-	if service.invalidTree() {
-		result["ready"] = false
-	} else {
-		result["ready"] = true
+	result["ready"] = service.ready()
+	if !service.ready() {
+		result["error"] = errNotReady
+		w.WriteHeader(errNotReady.status)
 	}
 
 	json.NewEncoder(w).Encode(result)
 }
 
-// KNNSearch will look for the k nearest neighbours of the given point
-// where the point is expected to be the uint representation of the phash of the image
+// KNNSearch will look for the k nearest neighbours of the given point,
+// where the point is an uploaded image, the `phash` of an image, or the
+// URL of an `image` to download and hash, see queryPoints. The parameters
+// are given in a JSON body or in a form, where `query` stands for k, see
+// searchRequest. The search is approximate when the optional `epsilon`
+// or `leaves` parameters are given, see vptree.SearchOptions.
 func (service *EngineAPI) KNNSearch(w http.ResponseWriter, r *http.Request) {
-	k, errK := strconv.ParseUint(r.FormValue("query"), 10, 64)
+	service.handleSearch(w, r, "k")
+}
+
+// RangeSearch will look all the points within `threshold` distance
+// of the given point, given as in KNNSearch, where `query` stands
+// for the threshold in forms
+func (service *EngineAPI) RangeSearch(w http.ResponseWriter, r *http.Request) {
+	service.handleSearch(w, r, "threshold")
+}
+
+// Search will run the KNN search, the range search, or the KNN search
+// limited to the neighbours within a threshold, depending on whether
+// `k`, `threshold` or both are given, see searchRequest
+// With `dihedral`, the rotated and mirrored copies of the query image are
+// found as well, see EngineAPI.points
+func (service *EngineAPI) Search(w http.ResponseWriter, r *http.Request) {
+	service.handleSearch(w, r, "")
+}
 
-	if errK != nil {
-		w.WriteHeader(http.StatusBadRequest)
+// handleSearch runs the search of the request, where the `required`
+// parameter also stands for the legacy `query` form field
+func (service *EngineAPI) handleSearch(w http.ResponseWriter, r *http.Request, required string) {
+	if err := service.parseRequest(w, r); err != nil {
+		writeError(w, requestError(err))
 		return
 	}
 
-	searchFnc := func(img image.Image) ([]map[string]interface{}, error) {
-		return service.knnSearch(img, uint(k))
+	req, err := parseSearchRequest(r, required)
+	if err == nil {
+		err = req.single()
 	}
 
-	service.search(w, r, searchFnc)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	service.search(w, r, req)
 }
 
-func (service *EngineAPI) knnSearch(img image.Image, k uint) ([]map[string]interface{}, error) {
-	searchFnc := func(queryPoint *engine.ImageInfo) (map[interface{}]float64, error) {
-		return service.Tree.KNNSearch(queryPoint, uint(k))
+// parseHash returns the query point of the hash written in `s`, which is
+// an extended hash of HashBits bits if set, see phash.ParseExt, or a PHash
+// otherwise, see phash.Parse
+func (service *EngineAPI) parseHash(s string) (*engine.ImageInfo, error) {
+	if service.HashBits > 0 {
+		ext, err := phash.ParseExt(s)
+		if err != nil {
+			return nil, err
+		}
+		if ext.Bits() != service.HashBits {
+			return nil, fmt.Errorf("Hash of %d bits, want %d bits", ext.Bits(), service.HashBits)
+		}
+		return engine.NewExtImageInfo(ext, ""), nil
 	}
-
-	return getResults(img, searchFnc)
+	hash, err := phash.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	return engine.NewImageInfoWithAlgorithm(hash, service.hasher().Algorithm(), ""), nil
 }
 
-// RangeSearch will look all the points within `threshold` distance
-// of the given point
-func (service *EngineAPI) RangeSearch(w http.ResponseWriter, r *http.Request) {
-	threshold, errT := strconv.ParseFloat(r.FormValue("query"), 64)
+// query runs the search described by `req` for each of the `queryPoints`,
+// and merges their results keeping the smallest distance of each image
+func (service *EngineAPI) query(ctx context.Context, queryPoints []*engine.ImageInfo, req *searchRequest) ([]index.Result[*engine.ImageInfo], bool, error) {
+	if len(queryPoints) == 1 {
+		results, partial, err := service.searchPoint(ctx, queryPoints[0], req)
+		return req.limit(results), partial, err
+	}
 
-	if errT != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		return
+	merged := make([]index.Result[*engine.ImageInfo], 0)
+	// the position of each image in merged
+	positions := make(map[*engine.ImageInfo]int)
+	anyPartial := false
+
+	for _, queryPoint := range queryPoints {
+		results, partial, err := service.searchPoint(ctx, queryPoint, req)
+		if err != nil {
+			return nil, false, err
+		}
+		anyPartial = anyPartial || partial
+		for _, result := range results {
+			if i, ok := positions[result.Point]; !ok {
+				positions[result.Point] = len(merged)
+				merged = append(merged, result)
+			} else if result.Distance < merged[i].Distance {
+				merged[i].Distance = result.Distance
+			}
+		}
+	}
+
+	// the ties keep the order of the first query point
+	index.SortResults(merged, nil)
+	// the k nearest images are among the k nearest of each query point
+	if req.K != nil && uint(len(merged)) > *req.K {
+		merged = merged[:*req.K]
+	}
+	return req.limit(merged), anyPartial, nil
+}
+
+// searchPoint runs the search described by `req` for `queryPoint`,
+// without limiting the number of results
+func (service *EngineAPI) searchPoint(ctx context.Context, queryPoint *engine.ImageInfo, req *searchRequest) ([]index.Result[*engine.ImageInfo], bool, error) {
+	if req.K == nil {
+		results, partial, err := service.rangeQuery(ctx, queryPoint, *req.Threshold)
+		if err != nil {
+			return nil, false, err
+		}
+		return req.filter(results), partial, nil
 	}
 
-	searchFnc := func(img image.Image) ([]map[string]interface{}, error) {
-		return service.rangeSearch(img, threshold)
+	k := *req.K
+	opts := req.options(service.Budget)
+	// the neighbours removed by the filters are replaced
+	// by searching for twice as many neighbours
+	for fetch := k; ; fetch *= 2 {
+		results, partial, err := service.knnQuery(ctx, queryPoint, fetch, opts)
+		if err != nil {
+			return nil, false, err
+		}
+
+		exhausted := uint(len(results)) < fetch || partial
+		if len(results) > 0 && req.Threshold != nil && results[len(results)-1].Distance > *req.Threshold {
+			// the next neighbours are all beyond the threshold
+			exhausted = true
+		}
+
+		kept := req.filter(results)
+		if uint(len(kept)) >= k || exhausted {
+			if uint(len(kept)) > k {
+				kept = kept[:k]
+			}
+			return kept, partial, nil
+		}
 	}
+}
 
-	service.search(w, r, searchFnc)
+func (service *EngineAPI) rangeQuery(ctx context.Context, queryPoint *engine.ImageInfo, threshold float64) ([]index.Result[*engine.ImageInfo], bool, error) {
+	if budgeted, ok := service.Index.(budgetedIndex); ok {
+		return budgeted.RangeSearchContext(ctx, queryPoint, threshold, service.Budget)
+	}
+	results, err := service.Index.RangeSearch(queryPoint, threshold)
+	return results, false, err
 }
 
-func (service *EngineAPI) rangeSearch(img image.Image, threshold float64) ([]map[string]interface{}, error) {
-	searchFnc := func(queryPoint *engine.ImageInfo) (map[interface{}]float64, error) {
-		return service.Tree.RangeSearch(queryPoint, threshold)
+func (service *EngineAPI) knnQuery(ctx context.Context, queryPoint *engine.ImageInfo, k uint, opts vptree.SearchOptions) ([]index.Result[*engine.ImageInfo], bool, error) {
+	if budgeted, ok := service.Index.(budgetedIndex); ok {
+		return budgeted.KNNSearchContext(ctx, queryPoint, k, opts)
 	}
+	results, err := service.Index.KNNSearch(queryPoint, k)
+	return results, false, err
+}
 
-	return getResults(img, searchFnc)
+// batchQuery is a query of a batch search, given either as
+// an uploaded image, as the PHash or as the URL of an image
+type batchQuery struct {
+	upload *multipart.FileHeader
+	point  *engine.ImageInfo
+	image  string
 }
 
-func getResults(img image.Image, searchFnc func(*engine.ImageInfo) (map[interface{}]float64, error)) ([]map[string]interface{}, error) {
-	hash := phash.GetPHash(img)
-	queryPoint := engine.NewImageInfo(hash, "")
-	searchResults, err := searchFnc(queryPoint)
+// KNNBatchSearch will look for the k nearest neighbours of each of the given
+// points, where the points are the uploaded images, then the `phash` values,
+// then the `image` URLs, of the request, see KNNSearch. In a JSON body, the
+// values after the first are given in the `phashes` and `images` lists. The
+// results of each query are returned in the same order, a query which
+// failed has an error instead of results.
+func (service *EngineAPI) KNNBatchSearch(w http.ResponseWriter, r *http.Request) {
+	if err := service.parseRequest(w, r); err != nil {
+		writeError(w, requestError(err))
+		return
+	}
+
+	req, err := parseSearchRequest(r, "k")
+
 	if err != nil {
-		return nil, err
+		writeError(w, err)
+		return
 	}
+
+	queries := make([]batchQuery, 0)
+	for _, upload := range uploads(r) {
+		queries = append(queries, batchQuery{upload: upload})
+	}
+	for _, value := range req.batchHashes() {
+		point, errH := service.parseHash(string(value))
+		if errH != nil {
+			writeError(w, invalidParameter("phash", errH))
+			return
+		}
+		queries = append(queries, batchQuery{point: point})
+	}
+	for _, imagePath := range req.batchImages() {
+		queries = append(queries, batchQuery{image: imagePath})
+	}
+	if len(queries) == 0 {
+		writeError(w, missingParameter("image"))
+		return
+	}
+	if len(queries) > maxBatchSize {
+		writeError(w, &apiError{
+			status:  http.StatusBadRequest,
+			Code:    codeInvalidParameter,
+			Message: "Too many queries, the limit is " + strconv.Itoa(maxBatchSize),
+		})
+		return
+	}
+
+	if !service.ready() {
+		writeError(w, errNotReady)
+		return
+	}
+	if err := service.checkAlgorithm(); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	ctx := r.Context()
+	batch := index.BatchSearch(ctx, queries, service.BatchWorkers, func(query batchQuery) ([]index.Result[*engine.ImageInfo], bool, error) {
+		if query.point != nil {
+			if req.Dihedral {
+				return nil, false, invalidParameter("dihedral", errDihedralPHash)
+			}
+			return service.query(ctx, []*engine.ImageInfo{query.point}, req)
+		}
+
+		var img image.Image
+		var err error
+		path, field := query.image, "image"
+		if query.upload != nil {
+			path, field = query.upload.Filename, uploadField
+			img, err = uploadImage(query.upload)
+		} else {
+			img, err = service.fetchImage(ctx, query.image)
+		}
+		if err != nil {
+			return nil, false, imageError(field, err)
+		}
+		points, err := service.points(img, path, req.Dihedral)
+		if err != nil {
+			return nil, false, imageError(field, err)
+		}
+		return service.query(ctx, points, req)
+	})
+	if ctx.Err() != nil {
+		// the client is gone, nobody is reading the response
+		return
+	}
+
+	results := make([]map[string]interface{}, len(batch))
+	for i, query := range queries {
+		elem := make(map[string]interface{})
+		if query.upload != nil {
+			elem["file"] = query.upload.Filename
+		} else if query.image != "" {
+			elem["image"] = query.image
+		} else {
+			elem["phash"] = formatHash(query.point)
+		}
+		if batch[i].Err != nil {
+			elem["error"] = toAPIError(batch[i].Err)
+		} else {
+			elem["results"] = formatResults(batch[i].Results)
+			elem["partial"] = batch[i].Partial
+		}
+		results[i] = elem
+	}
+
+	w.Header().Set(contentTypeKey, defaultContentType)
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(results)
+}
+
+func formatResults(searchResults []index.Result[*engine.ImageInfo]) []map[string]interface{} {
 	results := make([]map[string]interface{}, 0)
-	for k, v := range searchResults {
+	for _, result := range searchResults {
 		elem := make(map[string]interface{})
-		imgInfo := k.(*engine.ImageInfo)
-		imgInfoMap := map[string]interface{}{"path": imgInfo.GetPath(), "phash": imgInfo.GetPHash()}
-		elem["imageInfo"] = imgInfoMap
-		elem["distance"] = v
+		imgInfo := result.Point
+		elem["imageInfo"] = formatImageInfo(imgInfo)
+		elem["distance"] = result.Distance
 		results = append(results, elem)
 	}
-	return results, nil
+	return results
+}
+
+// formatHash returns the PHash of the image as a JSON number, or its
+// extended hash as a string, see phash.ParseExt
+func formatHash(imgInfo *engine.ImageInfo) interface{} {
+	if imgInfo.IsExtended() {
+		return imgInfo.GetExtPHash().String()
+	}
+	return imgInfo.GetPHash()
 }
 
-func (service *EngineAPI) search(w http.ResponseWriter, r *http.Request, searchFnc func(img image.Image) ([]map[string]interface{}, error)) {
+func formatImageInfo(imgInfo *engine.ImageInfo) map[string]interface{} {
+	result := map[string]interface{}{
+		"path":      imgInfo.GetPath(),
+		"phash":     formatHash(imgInfo),
+		"bits":      imgInfo.GetBits(),
+		"algorithm": imgInfo.GetAlgorithm().String(),
+	}
+	if regions := imgInfo.GetRegions(); len(regions) > 0 {
+		result["regions"] = regions
+	}
+	return result
+}
+
+// Insert will add the image at the given path to the engine,
+// making it searchable right away. The image is rejected when
+// the index holds hashes computed by another algorithm, see
+// EngineAPI.Algorithm.
+func (service *EngineAPI) Insert(w http.ResponseWriter, r *http.Request) {
 	imagePath := r.FormValue("image")
 
-	if service.invalidTree() {
-		w.WriteHeader(http.StatusInternalServerError)
+	if service.Index == nil {
+		writeError(w, errNotReady)
+		return
+	}
+	if imagePath == "" {
+		writeError(w, missingParameter("image"))
+		return
+	}
+	if err := service.checkAlgorithm(); err != nil {
+		writeError(w, err)
+		return
+	}
+	img, err := service.fetchImage(r.Context(), imagePath)
+	if err != nil {
+		writeError(w, imageError("image", err))
+		return
+	}
+	imgInfo, err := service.imageInfo(img, imagePath)
+	if err != nil {
+		writeError(w, imageError("image", err))
+		return
+	}
+	service.Index.Insert(imgInfo)
+
+	w.Header().Set(contentTypeKey, defaultContentType)
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(formatImageInfo(imgInfo))
+}
+
+// Delete will remove the image stored with the path `image` from the
+// engine. The image is found by its `phash`, as returned by Insert, or
+// by the hash of the image downloaded from `image` when it is not given.
+func (service *EngineAPI) Delete(w http.ResponseWriter, r *http.Request) {
+	imagePath := r.FormValue("image")
+
+	if !service.ready() {
+		writeError(w, errNotReady)
+		return
+	}
+	if imagePath == "" {
+		writeError(w, missingParameter("image"))
+		return
+	}
+	if err := service.checkAlgorithm(); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	var imgInfo *engine.ImageInfo
+	if hash := r.FormValue("phash"); hash != "" {
+		point, err := service.parseHash(hash)
+		if err != nil {
+			writeError(w, invalidParameter("phash", err))
+			return
+		}
+		imgInfo = point
+	} else {
+		img, err := service.fetchImage(r.Context(), imagePath)
+		if err != nil {
+			writeError(w, imageError("image", err))
+			return
+		}
+		if imgInfo, err = service.imageInfo(img, imagePath); err != nil {
+			writeError(w, imageError("image", err))
+			return
+		}
+	}
+
+	// the stored image has the same hash, hence it is at distance 0
+	candidates, _ := service.Index.RangeSearch(imgInfo, 0)
+	for _, candidate := range candidates {
+		if candidate.Point.GetPath() == imagePath && service.Index.Delete(candidate.Point) {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+	}
+	writeError(w, &apiError{
+		status:  http.StatusNotFound,
+		Code:    codeNotFound,
+		Message: "Image not found in the index",
+		Field:   "image",
+	})
+}
+
+func (service *EngineAPI) search(w http.ResponseWriter, r *http.Request, req *searchRequest) {
+	if !service.ready() {
+		writeError(w, errNotReady)
+	} else if errAlg := service.checkAlgorithm(); errAlg != nil {
+		writeError(w, errAlg)
 	} else {
-		resp, errG := http.Get(imagePath)
-		if errG != nil || resp.StatusCode != http.StatusOK {
-			w.WriteHeader(http.StatusBadRequest)
+		queryPoints, errHash := service.queryPoints(r, req)
+		if errHash != nil {
+			writeError(w, toAPIError(errHash))
 			return
 		}
-		img, _, errImg := image.Decode(resp.Body)
-		if errImg != nil {
-			w.WriteHeader(http.StatusBadRequest)
+		searchResults, partial, errSearch := service.query(r.Context(), queryPoints, req)
+		if r.Context().Err() != nil {
+			// the client is gone, nobody is reading the response
 			return
 		}
-		results, errSearch := searchFnc(img)
 		if errSearch != nil {
-			w.WriteHeader(http.StatusInternalServerError)
+			writeError(w, toAPIError(errSearch))
 		} else {
+			if partial {
+				w.Header().Set(partialResultsKey, "true")
+			}
+			w.Header().Set(contentTypeKey, defaultContentType)
 			w.WriteHeader(http.StatusOK)
-			json.NewEncoder(w).Encode(results)
+			json.NewEncoder(w).Encode(formatResults(searchResults))
 		}
 	}
 }
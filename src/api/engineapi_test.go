@@ -0,0 +1,562 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	engine "github.com/jx3yang/imgsearchengine/src/engine"
+	index "github.com/jx3yang/imgsearchengine/src/index"
+	phash "github.com/jx3yang/imgsearchengine/src/phash"
+)
+
+func testService() *EngineAPI {
+	points := []*engine.ImageInfo{
+		engine.NewImageInfo(0b0001, "a.png"),
+		engine.NewImageInfo(0b0011, "b.png"),
+		engine.NewImageInfo(0b1111, "c.png"),
+	}
+	return &EngineAPI{Index: index.NewLinear(points, engine.HashDistance)}
+}
+
+func postForm(handler http.HandlerFunc, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(form.Encode()))
+	req.Header.Set(contentTypeKey, "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	handler(w, req)
+	return w
+}
+
+// decodeError returns the error envelope of a response
+func decodeError(t *testing.T, w *httptest.ResponseRecorder) apiError {
+	var body struct {
+		Error apiError `json:"error"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("invalid error body: %v", err)
+	}
+	return body.Error
+}
+
+func TestKNNSearchPHash(t *testing.T) {
+	// arrange
+	service := testService()
+
+	// act
+	w := postForm(service.KNNSearch, url.Values{"query": {"2"}, "phash": {"0b0111"}})
+
+	// assert
+	var got []map[string]interface{}
+	json.NewDecoder(w.Body).Decode(&got)
+	if w.Code != http.StatusOK || len(got) != 2 {
+		t.Fatalf("KNNSearch() = %d with %d results, want %d with 2 results", w.Code, len(got), http.StatusOK)
+	}
+	if got[0]["distance"] != 1./64 || got[1]["distance"] != 1./64 {
+		t.Errorf("KNNSearch() = %v, want the two images at 1 bit", got)
+	}
+}
+
+func postJSON(handler http.HandlerFunc, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	req.Header.Set(contentTypeKey, defaultContentType)
+	w := httptest.NewRecorder()
+	handler(w, req)
+	return w
+}
+
+// paths returns the paths of the images found by a search
+func paths(t *testing.T, w *httptest.ResponseRecorder) []string {
+	var results []struct {
+		ImageInfo struct {
+			Path string `json:"path"`
+		} `json:"imageInfo"`
+	}
+	if w.Code != http.StatusOK {
+		t.Fatalf("search failed with %d: %s", w.Code, w.Body.String())
+	}
+	json.NewDecoder(w.Body).Decode(&results)
+	paths := make([]string, len(results))
+	for i, result := range results {
+		paths[i] = result.ImageInfo.Path
+	}
+	return paths
+}
+
+func TestSearchRequest(t *testing.T) {
+	// arrange
+	points := []*engine.ImageInfo{
+		engine.NewImageInfo(0b0000, "cats/a.png"),
+		engine.NewImageInfo(0b0001, "dogs/b.png"),
+		engine.NewImageInfo(0b0011, "dogs/c.png"),
+		engine.NewImageInfo(0b0111, "cats/d.png"),
+		engine.NewImageInfo(0b1111, "cats/e.png"),
+	}
+	linear := index.NewLinear(points, engine.HashDistance)
+	linear.SetTieBreaker(func(img1, img2 *engine.ImageInfo) bool { return img1.GetPath() < img2.GetPath() })
+	service := &EngineAPI{Index: linear}
+
+	tests := []struct {
+		name string
+		body string
+		want []string
+	}{
+		{"knn", `{"k": 2, "phash": "0b0000"}`, []string{"cats/a.png", "dogs/b.png"}},
+		{"range", `{"threshold": 0.03125, "phash": 1}`, []string{"dogs/b.png", "cats/a.png", "dogs/c.png", "cats/d.png"}},
+		{"knn within threshold", `{"k": 4, "threshold": 0.015625, "phash": "0x0"}`, []string{"cats/a.png", "dogs/b.png"}},
+		{"filtered knn", `{"k": 2, "phash": "0", "pathPrefix": "cats/"}`, []string{"cats/a.png", "cats/d.png"}},
+		{"filtered range", `{"threshold": 1, "phash": "0", "pathPrefix": "dogs/"}`, []string{"dogs/b.png", "dogs/c.png"}},
+		{"limit", `{"threshold": 1, "phash": "0", "limit": 3}`, []string{"cats/a.png", "dogs/b.png", "dogs/c.png"}},
+	}
+
+	for _, test := range tests {
+		// act
+		got := paths(t, postJSON(service.Search, test.body))
+
+		// assert
+		if !reflect.DeepEqual(test.want, got) {
+			t.Errorf("%s: Search() = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestSearchRequestInvalidJSON(t *testing.T) {
+	// arrange
+	service := testService()
+
+	for _, body := range []string{`{"k": 1, "phash": "1", "unknown": 2}`, `{"k": -1}`, `{"k": 1`, `{"k": 1, "phash": "1", "phashes": ["2"]}`} {
+		// act
+		w := postJSON(service.KNNSearch, body)
+
+		// assert
+		got := decodeError(t, w)
+		if w.Code != http.StatusBadRequest || got.Code != codeInvalidParameter {
+			t.Errorf("KNNSearch(%s) = %d %+v, want %d with code %q", body, w.Code, got, http.StatusBadRequest, codeInvalidParameter)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	service := testService()
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		form    url.Values
+		status  int
+		code    string
+		field   string
+	}{
+		{"missing k", service.KNNSearch, url.Values{"phash": {"1"}}, http.StatusBadRequest, codeMissingParameter, "k"},
+		{"invalid k", service.KNNSearch, url.Values{"query": {"ten"}, "phash": {"1"}}, http.StatusBadRequest, codeInvalidParameter, "k"},
+		{"zero k", service.KNNSearch, url.Values{"query": {"0"}, "phash": {"1"}}, http.StatusBadRequest, codeInvalidParameter, "k"},
+		{"invalid epsilon", service.KNNSearch, url.Values{"query": {"1"}, "phash": {"1"}, "epsilon": {"-1"}}, http.StatusBadRequest, codeInvalidParameter, "epsilon"},
+		{"invalid phash", service.KNNSearch, url.Values{"query": {"1"}, "phash": {"0xzz"}}, http.StatusBadRequest, codeInvalidParameter, "phash"},
+		{"missing image", service.KNNSearch, url.Values{"query": {"1"}}, http.StatusBadRequest, codeMissingParameter, "image"},
+		{"blocked image", service.KNNSearch, url.Values{"query": {"1"}, "image": {"http://127.0.0.1/a.png"}}, http.StatusBadRequest, codeURLNotAllowed, "image"},
+		{"invalid URL", service.KNNSearch, url.Values{"query": {"1"}, "image": {"http://[::1"}}, http.StatusBadRequest, codeInvalidParameter, "image"},
+		{"negative threshold", service.RangeSearch, url.Values{"query": {"-0.1"}, "phash": {"1"}}, http.StatusBadRequest, codeInvalidParameter, "threshold"},
+		{"NaN threshold", service.RangeSearch, url.Values{"threshold": {"NaN"}, "phash": {"1"}}, http.StatusBadRequest, codeInvalidParameter, "threshold"},
+		{"infinite threshold", service.Search, url.Values{"threshold": {"+Inf"}, "phash": {"1"}}, http.StatusBadRequest, codeInvalidParameter, "threshold"},
+		{"NaN epsilon", service.KNNSearch, url.Values{"k": {"1"}, "phash": {"1"}, "epsilon": {"NaN"}}, http.StatusBadRequest, codeInvalidParameter, "epsilon"},
+		{"several phashes", service.KNNSearch, url.Values{"k": {"1"}, "phash": {"1", "2"}}, http.StatusBadRequest, codeInvalidParameter, "phashes"},
+		{"missing mode", service.Search, url.Values{"phash": {"1"}}, http.StatusBadRequest, codeMissingParameter, "k"},
+		{"invalid limit", service.Search, url.Values{"k": {"1"}, "phash": {"1"}, "limit": {"-1"}}, http.StatusBadRequest, codeInvalidParameter, "limit"},
+		{"empty batch", service.KNNBatchSearch, url.Values{"query": {"1"}}, http.StatusBadRequest, codeMissingParameter, "image"},
+		{"missing insert", service.Insert, url.Values{}, http.StatusBadRequest, codeMissingParameter, "image"},
+		{"not ready", (&EngineAPI{}).KNNSearch, url.Values{"query": {"1"}, "phash": {"1"}}, http.StatusServiceUnavailable, codeNotReady, ""},
+	}
+
+	for _, test := range tests {
+		// act
+		w := postForm(test.handler, test.form)
+
+		// assert
+		got := decodeError(t, w)
+		if w.Code != test.status || got.Code != test.code || got.Field != test.field || got.Message == "" {
+			t.Errorf("%s: got %d %+v, want %d with code %q and field %q", test.name, w.Code, got, test.status, test.code, test.field)
+		}
+		if w.Header().Get(contentTypeKey) != defaultContentType {
+			t.Errorf("%s: got content type %q", test.name, w.Header().Get(contentTypeKey))
+		}
+	}
+}
+
+func TestPingNotReady(t *testing.T) {
+	// arrange
+	service := &EngineAPI{}
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	w := httptest.NewRecorder()
+
+	// act
+	service.Ping(w, req)
+
+	// assert
+	var body struct {
+		Ready bool     `json:"ready"`
+		Error apiError `json:"error"`
+	}
+	json.NewDecoder(w.Body).Decode(&body)
+	if w.Code != http.StatusServiceUnavailable || body.Ready || body.Error.Code != codeNotReady {
+		t.Errorf("Ping() = %d %+v, want %d with code %q", w.Code, body, http.StatusServiceUnavailable, codeNotReady)
+	}
+}
+
+func TestSearchHasher(t *testing.T) {
+	// arrange
+	img := image.NewGray(image.Rect(0, 0, 32, 32))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i * 7)
+	}
+	var body bytes.Buffer
+	png.Encode(&body, img)
+
+	hasher := phash.AverageHasher{}
+	hash, _ := hasher.Hash(img)
+	points := []*engine.ImageInfo{
+		engine.NewImageInfoWithAlgorithm(hash, phash.Average, "a.png"),
+		engine.NewImageInfoWithAlgorithm(^hash, phash.Average, "b.png"),
+	}
+	service := &EngineAPI{Index: index.NewLinear(points, engine.HashDistance), Hasher: hasher, Algorithm: phash.Average}
+
+	req := httptest.NewRequest(http.MethodPost, "/?k=1", &body)
+	req.Header.Set(contentTypeKey, "image/png")
+	w := httptest.NewRecorder()
+
+	// act
+	service.Search(w, req)
+
+	// assert
+	var got []struct {
+		ImageInfo map[string]interface{} `json:"imageInfo"`
+		Distance  float64                `json:"distance"`
+	}
+	json.NewDecoder(w.Body).Decode(&got)
+	if w.Code != http.StatusOK || len(got) != 1 {
+		t.Fatalf("Search() = %d with %d results, want %d with 1 result", w.Code, len(got), http.StatusOK)
+	}
+	if got[0].ImageInfo["path"] != "a.png" || got[0].ImageInfo["algorithm"] != "average" || got[0].Distance != 0 {
+		t.Errorf("Search() = %v, want a.png hashed by average at distance 0", got[0])
+	}
+}
+
+func TestSearchExtendedHash(t *testing.T) {
+	// arrange
+	hash := phash.ExtPHash{0b0001, 0, 0, 1}
+	points := []*engine.ImageInfo{
+		engine.NewExtImageInfo(hash, "a.png"),
+		engine.NewExtImageInfo(phash.ExtPHash{0b0011, 0, 1, 1}, "b.png"),
+	}
+	service := &EngineAPI{Index: index.NewLinear(points, engine.HashDistance), HashBits: 256}
+
+	// act
+	w := postJSON(service.Search, `{"k": 2, "phash": "`+hash.String()+`"}`)
+	wShort := postJSON(service.Search, `{"k": 2, "phash": "0x2a"}`)
+
+	// assert
+	var got []struct {
+		ImageInfo map[string]interface{} `json:"imageInfo"`
+		Distance  float64                `json:"distance"`
+	}
+	json.NewDecoder(w.Body).Decode(&got)
+	if w.Code != http.StatusOK || len(got) != 2 {
+		t.Fatalf("Search() = %d with %d results, want %d with 2 results", w.Code, len(got), http.StatusOK)
+	}
+	if got[0].ImageInfo["phash"] != hash.String() || got[0].ImageInfo["bits"] != 256. || got[0].Distance != 0 {
+		t.Errorf("Search() = %v, want a.png with its 256 bits hash at distance 0", got[0])
+	}
+	if got[1].Distance != 2./256 {
+		t.Errorf("Search() = %v, want b.png at 2 bits", got[1])
+	}
+	if err := decodeError(t, wShort); wShort.Code != http.StatusBadRequest || err.Field != "phash" {
+		t.Errorf("Search() with a short hash = %d %v, want %d on phash", wShort.Code, err, http.StatusBadRequest)
+	}
+}
+
+func TestSearchCorruptUpload(t *testing.T) {
+	// arrange
+	service := testService()
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, _ := writer.CreateFormFile(uploadField, "broken.png")
+	part.Write([]byte("\x89PNG not really"))
+	writer.WriteField("k", "1")
+	writer.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/", &body)
+	req.Header.Set(contentTypeKey, writer.FormDataContentType())
+	w := httptest.NewRecorder()
+
+	// act
+	service.Search(w, req)
+
+	// assert
+	err := decodeError(t, w)
+	if w.Code != http.StatusUnprocessableEntity || err.Code != codeInvalidImage || err.Field != uploadField {
+		t.Errorf("Search() = %d %v, want %d %s on %s", w.Code, err, http.StatusUnprocessableEntity, codeInvalidImage, uploadField)
+	}
+	if !strings.Contains(err.Message, "broken.png") {
+		t.Errorf("Search() error %q does not name the upload", err.Message)
+	}
+}
+
+func TestSearchDihedral(t *testing.T) {
+	// arrange
+	img := image.NewGray(image.Rect(0, 0, 40, 30))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i*i + i/7)
+	}
+	rotated, _ := phash.GetPHash(phash.Transformed(img, phash.Rotate90))
+	points := []*engine.ImageInfo{
+		engine.NewImageInfo(rotated, "rotated.png"),
+		engine.NewImageInfo(^rotated, "other.png"),
+	}
+	service := &EngineAPI{Index: index.NewLinear(points, engine.HashDistance)}
+
+	search := func(dihedral string) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		png.Encode(&body, img)
+		req := httptest.NewRequest(http.MethodPost, "/?k=1&dihedral="+dihedral, &body)
+		req.Header.Set(contentTypeKey, "image/png")
+		w := httptest.NewRecorder()
+		service.Search(w, req)
+		return w
+	}
+
+	// act
+	w := search("true")
+	wPlain := search("false")
+	wPHash := postJSON(service.Search, `{"k": 1, "phash": "0b1", "dihedral": true}`)
+
+	// assert
+	var got, gotPlain []struct {
+		Distance float64 `json:"distance"`
+	}
+	json.NewDecoder(w.Body).Decode(&got)
+	json.NewDecoder(wPlain.Body).Decode(&gotPlain)
+	if len(got) != 1 || got[0].Distance != 0 {
+		t.Errorf("Search() with dihedral = %v, want the rotated image at distance 0", got)
+	}
+	if len(gotPlain) != 1 || gotPlain[0].Distance == 0 {
+		t.Errorf("Search() without dihedral = %v, want no image at distance 0", gotPlain)
+	}
+	if err := decodeError(t, wPHash); wPHash.Code != http.StatusBadRequest || err.Field != "dihedral" {
+		t.Errorf("Search() with dihedral and a phash = %d %v, want %d on dihedral", wPHash.Code, err, http.StatusBadRequest)
+	}
+}
+
+func TestSearchCropResistant(t *testing.T) {
+	// arrange
+	// bright textured rectangles over a dark background
+	img := image.NewGray(image.Rect(0, 0, 300, 300))
+	rects := []image.Rectangle{image.Rect(30, 40, 120, 130), image.Rect(170, 60, 260, 120), image.Rect(60, 180, 200, 270)}
+	for y := 0; y < 300; y++ {
+		for x := 0; x < 300; x++ {
+			value := 30 + (x*y/40)%40
+			for i, rect := range rects {
+				if (image.Point{x, y}).In(rect) {
+					value = 170 + (x*(i+2)+y*(i+5))%80
+				}
+			}
+			img.SetGray(x, y, color.Gray{Y: uint8(value)})
+		}
+	}
+	crop := img.SubImage(image.Rect(0, 0, 150, 160))
+
+	hashes, _ := phash.CropResistantHashes(img, phash.PerceptionHasher{})
+	points := []*engine.ImageInfo{
+		engine.NewRegionImageInfo(hashes, phash.Perception, "full.png"),
+		engine.NewRegionImageInfo([]phash.PHash{^hashes[0]}, phash.Perception, "other.png"),
+	}
+	service := &EngineAPI{Index: index.NewLinear(points, engine.RegionDistance), CropResistant: true}
+
+	var body bytes.Buffer
+	png.Encode(&body, crop)
+	req := httptest.NewRequest(http.MethodPost, "/?k=1", &body)
+	req.Header.Set(contentTypeKey, "image/png")
+	w := httptest.NewRecorder()
+
+	// act
+	service.Search(w, req)
+
+	// assert
+	var got []struct {
+		ImageInfo map[string]interface{} `json:"imageInfo"`
+		Distance  float64                `json:"distance"`
+	}
+	json.NewDecoder(w.Body).Decode(&got)
+	if w.Code != http.StatusOK || len(got) != 1 {
+		t.Fatalf("Search() = %d with %d results, want %d with 1 result", w.Code, len(got), http.StatusOK)
+	}
+	if got[0].ImageInfo["path"] != "full.png" || got[0].Distance > 10./64 {
+		t.Errorf("Search() = %v, want full.png within 10 bits", got[0])
+	}
+	if regions, _ := got[0].ImageInfo["regions"].([]interface{}); len(regions) != len(hashes)-1 {
+		t.Errorf("Search() = %v, want the %d regions of full.png", got[0], len(hashes)-1)
+	}
+}
+
+func TestDeletePHash(t *testing.T) {
+	// arrange
+	service := testService()
+
+	// act
+	// the URL of the image is never fetched when its phash is given
+	wWrongPath := postForm(service.Delete, url.Values{"image": {"http://127.0.0.1/c.png"}, "phash": {"15"}})
+	w := postForm(service.Delete, url.Values{"image": {"c.png"}, "phash": {"15"}})
+	wAgain := postForm(service.Delete, url.Values{"image": {"c.png"}, "phash": {"15"}})
+
+	// assert
+	if err := decodeError(t, wWrongPath); wWrongPath.Code != http.StatusNotFound || err.Code != codeNotFound {
+		t.Errorf("Delete() of another path = %d %v, want %d", wWrongPath.Code, err, http.StatusNotFound)
+	}
+	if w.Code != http.StatusOK || service.Index.Len() != 2 {
+		t.Errorf("Delete() = %d with %d images left, want %d with 2 images", w.Code, service.Index.Len(), http.StatusOK)
+	}
+	if wAgain.Code != http.StatusNotFound {
+		t.Errorf("Delete() of a deleted image = %d, want %d", wAgain.Code, http.StatusNotFound)
+	}
+}
+
+func TestKNNBatchSearch(t *testing.T) {
+	// arrange
+	server := imageServer(t)
+	grayHash, _ := phash.GetPHash(image.NewGray(image.Rect(0, 0, 32, 32)))
+	points := []*engine.ImageInfo{
+		engine.NewImageInfo(grayHash, "gray.png"),
+		engine.NewImageInfo(0b0001, "a.png"),
+		engine.NewImageInfo(0b1111, "c.png"),
+	}
+	service := &EngineAPI{Index: index.NewLinear(points, engine.HashDistance), Fetcher: &Fetcher{AllowPrivate: true}}
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	uploaded := []struct {
+		name string
+		data []byte
+	}{{"upload.png", pngImage()}, {"broken.png", []byte("\x89PNG not really")}}
+	for _, upload := range uploaded {
+		part, _ := writer.CreateFormFile(uploadField, upload.name)
+		part.Write(upload.data)
+	}
+	writer.WriteField("k", "1")
+	writer.WriteField("phash", "0b0001")
+	writer.WriteField("phash", "0b0111")
+	writer.WriteField("image", server.URL+"/image")
+	writer.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/knn/batch", &body)
+	req.Header.Set(contentTypeKey, writer.FormDataContentType())
+	w := httptest.NewRecorder()
+
+	// act
+	service.KNNBatchSearch(w, req)
+
+	// assert
+	var got []struct {
+		File    string      `json:"file"`
+		PHash   interface{} `json:"phash"`
+		Image   string      `json:"image"`
+		Error   *apiError   `json:"error"`
+		Results []struct {
+			ImageInfo struct {
+				Path string `json:"path"`
+			} `json:"imageInfo"`
+		} `json:"results"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil || w.Code != http.StatusOK || len(got) != 5 {
+		t.Fatalf("KNNBatchSearch() = %d with %d queries, want %d with 5 queries", w.Code, len(got), http.StatusOK)
+	}
+	// the uploads come first in the order of the form, then the phashes, then the images
+	wantPaths := map[string]string{"upload.png": "gray.png", "1": "a.png", "7": "c.png", server.URL + "/image": "gray.png"}
+	for i, query := range got {
+		key := query.File + query.Image
+		if query.PHash != nil {
+			key = strconv.Itoa(int(query.PHash.(float64)))
+		}
+		if key == "broken.png" {
+			if query.Error == nil || query.Error.Code != codeInvalidImage || query.Error.Field != uploadField {
+				t.Errorf("query %d: got %+v, want the error of the broken upload", i, query)
+			}
+			continue
+		}
+		if len(query.Results) != 1 || query.Results[0].ImageInfo.Path != wantPaths[key] {
+			t.Errorf("query %d (%s): got %+v, want %s", i, key, query.Results, wantPaths[key])
+		}
+	}
+	if got[0].File != "upload.png" || got[1].File != "broken.png" || got[2].PHash != 1. || got[3].PHash != 7. || got[4].Image != server.URL+"/image" {
+		t.Errorf("KNNBatchSearch() = %+v, want the uploads, then the phashes, then the images", got)
+	}
+}
+
+func TestKNNBatchSearchJSON(t *testing.T) {
+	// arrange
+	service := testService()
+
+	// act
+	w := postJSON(service.KNNBatchSearch, `{"k": 1, "phash": "0b0001", "phashes": ["0b0111", 15]}`)
+
+	// assert
+	var got []struct {
+		PHash   uint64 `json:"phash"`
+		Results []struct {
+			ImageInfo struct {
+				Path string `json:"path"`
+			} `json:"imageInfo"`
+		} `json:"results"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil || w.Code != http.StatusOK || len(got) != 3 {
+		t.Fatalf("KNNBatchSearch() = %d with %d queries, want %d with 3 queries", w.Code, len(got), http.StatusOK)
+	}
+	for i, want := range []struct {
+		hash uint64
+		path string
+	}{{0b0001, "a.png"}, {0b0111, "b.png"}, {0b1111, "c.png"}} {
+		if got[i].PHash != want.hash || len(got[i].Results) != 1 || got[i].Results[0].ImageInfo.Path != want.path {
+			t.Errorf("query %d = %+v, want %s for %d", i, got[i], want.path, want.hash)
+		}
+	}
+}
+
+func TestAlgorithmMismatch(t *testing.T) {
+	// arrange
+	server := imageServer(t)
+	points := []*engine.ImageInfo{
+		engine.NewImageInfoWithAlgorithm(0b0101, phash.Average, "a.png"),
+		engine.NewImageInfoWithAlgorithm(0b1111, phash.Average, "b.png"),
+	}
+	// the queries are hashed by the default perception hasher
+	service := &EngineAPI{
+		Index:     index.NewLinear(points, engine.HashDistance),
+		Algorithm: phash.Average,
+		Fetcher:   &Fetcher{AllowPrivate: true},
+	}
+
+	// act
+	responses := map[string]*httptest.ResponseRecorder{
+		"knn":    postJSON(service.Search, `{"k": 1, "phash": "0b0101"}`),
+		"range":  postJSON(service.Search, `{"threshold": 0.1, "phash": "0b0101"}`),
+		"batch":  postJSON(service.KNNBatchSearch, `{"k": 1, "phash": "0b0101"}`),
+		"insert": postForm(service.Insert, url.Values{"image": {server.URL + "/image"}}),
+		"delete": postForm(service.Delete, url.Values{"image": {"a.png"}, "phash": {"0b0101"}}),
+	}
+
+	// assert
+	for name, w := range responses {
+		if err := decodeError(t, w); w.Code != http.StatusConflict || err.Code != codeAlgorithmMismatch {
+			t.Errorf("%s: got %d %+v, want %d with code %q", name, w.Code, err, http.StatusConflict, codeAlgorithmMismatch)
+		}
+	}
+	if service.Index.Len() != 2 {
+		t.Errorf("Len() = %d after inserting and deleting perception hashes, want 2", service.Index.Len())
+	}
+}
@@ -0,0 +1,125 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	phash "github.com/jx3yang/imgsearchengine/src/phash"
+)
+
+// codes of the errors returned by the API
+const (
+	codeInvalidParameter  = "invalid_parameter"
+	codeMissingParameter  = "missing_parameter"
+	codeRequestTooLarge   = "request_too_large"
+	codeUnsupportedMedia  = "unsupported_media_type"
+	codeURLNotAllowed     = "url_not_allowed"
+	codeFetchFailed       = "fetch_failed"
+	codeInvalidImage      = "invalid_image"
+	codeNotReady          = "not_ready"
+	codeNotFound          = "not_found"
+	codeAlgorithmMismatch = "algorithm_mismatch"
+	codeSearchFailed      = "search_failed"
+)
+
+// apiError is returned in the "error" field of the body of every failed
+// request. Field is the request parameter at fault, if any.
+type apiError struct {
+	status  int
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Field   string `json:"field,omitempty"`
+}
+
+func (err *apiError) Error() string {
+	return err.Message
+}
+
+var errNotReady = &apiError{
+	status:  http.StatusServiceUnavailable,
+	Code:    codeNotReady,
+	Message: "The index is not loaded",
+}
+
+func invalidParameter(field string, err error) *apiError {
+	return &apiError{
+		status:  http.StatusBadRequest,
+		Code:    codeInvalidParameter,
+		Message: fmt.Sprintf("Invalid %s: %v", field, err),
+		Field:   field,
+	}
+}
+
+func missingParameter(field string) *apiError {
+	return &apiError{
+		status:  http.StatusBadRequest,
+		Code:    codeMissingParameter,
+		Message: "Missing " + field,
+		Field:   field,
+	}
+}
+
+// algorithmError reports that the index holds hashes computed by `indexed`,
+// which cannot be compared to the hashes of the request computed by `hashed`
+func algorithmError(indexed, hashed phash.Algorithm) *apiError {
+	return &apiError{
+		status:  http.StatusConflict,
+		Code:    codeAlgorithmMismatch,
+		Message: fmt.Sprintf("The index holds %s hashes, not %s hashes", indexed, hashed),
+	}
+}
+
+// requestError converts the errors of parseRequest
+func requestError(err error) *apiError {
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case err == errBodyTooLarge, errors.As(err, &maxBytesErr):
+		return &apiError{status: http.StatusRequestEntityTooLarge, Code: codeRequestTooLarge, Message: err.Error()}
+	case err == errUnsupportedMediaType:
+		return &apiError{status: http.StatusUnsupportedMediaType, Code: codeUnsupportedMedia, Message: err.Error()}
+	}
+	return &apiError{status: http.StatusBadRequest, Code: codeInvalidParameter, Message: err.Error()}
+}
+
+// imageError converts the errors of reading the query image given in `field`
+func imageError(field string, err error) *apiError {
+	apiErr := &apiError{Message: err.Error(), Field: field}
+	var urlErr *url.Error
+	// the raw image bodies are limited while being decoded
+	var maxBytesErr *http.MaxBytesError
+
+	switch {
+	case errors.Is(err, errInvalidURL):
+		apiErr.status, apiErr.Code = http.StatusBadRequest, codeInvalidParameter
+	case errors.Is(err, errSchemeNotAllowed), errors.Is(err, errHostNotAllowed),
+		errors.Is(err, errAddressBlocked), errors.Is(err, errTooManyRedirects):
+		apiErr.status, apiErr.Code = http.StatusBadRequest, codeURLNotAllowed
+	case errors.Is(err, errImageTooLarge), errors.Is(err, errTooManyPixels), errors.As(err, &maxBytesErr):
+		apiErr.status, apiErr.Code = http.StatusRequestEntityTooLarge, codeRequestTooLarge
+	case errors.Is(err, errUnexpectedStatus), errors.As(err, &urlErr):
+		apiErr.status, apiErr.Code = http.StatusBadGateway, codeFetchFailed
+	default:
+		// the image was read, but could not be decoded
+		apiErr.status, apiErr.Code = http.StatusUnprocessableEntity, codeInvalidImage
+	}
+	return apiErr
+}
+
+// toAPIError returns `err` if it is an apiError, and reports the
+// other errors as failures of the search
+func toAPIError(err error) *apiError {
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+	return &apiError{status: http.StatusInternalServerError, Code: codeSearchFailed, Message: err.Error()}
+}
+
+func writeError(w http.ResponseWriter, err *apiError) {
+	w.Header().Set(contentTypeKey, defaultContentType)
+	w.WriteHeader(err.status)
+	json.NewEncoder(w).Encode(map[string]interface{}{"error": err})
+}
@@ -0,0 +1,209 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultFetchTimeout  = 10 * time.Second
+	defaultMaxRedirects  = 3
+	defaultMaxImageBytes = 10 << 20
+)
+
+var defaultSchemes = []string{"http", "https"}
+
+var (
+	errInvalidURL       = errors.New("Invalid URL")
+	errSchemeNotAllowed = errors.New("URL scheme not allowed")
+	errHostNotAllowed   = errors.New("URL host not allowed")
+	errAddressBlocked   = errors.New("URL resolves to a blocked address")
+	errTooManyRedirects = errors.New("Too many redirects")
+	errImageTooLarge    = errors.New("Image too large")
+	errInvalidMediaType = errors.New("URL does not point to an image")
+	errUnexpectedStatus = errors.New("Unexpected status fetching the image")
+)
+
+// carrier-grade NAT range, not covered by net.IP.IsPrivate
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// Fetcher downloads the images given by URL to the API. Since the URLs come
+// from the clients, it only connects to public addresses by default, so the
+// API cannot be used to reach the internal network of the server. The zero
+// value is ready to use, and a Fetcher must not be modified once used.
+type Fetcher struct {
+	// AllowedSchemes defaults to http and https
+	AllowedSchemes []string
+	// AllowedHosts restricts the hosts of the URLs when not empty, a host
+	// starting with a dot also allows all of its subdomains
+	AllowedHosts []string
+	// AllowPrivate allows the loopback, private and link-local addresses
+	AllowPrivate bool
+	// MaxRedirects defaults to 3, a negative value disables the redirects
+	MaxRedirects int
+	// Timeout bounds the whole download, it defaults to 10s
+	Timeout time.Duration
+	// MaxBytes is the size limit of the images, it defaults to 10MB
+	MaxBytes int64
+
+	once   sync.Once
+	client *http.Client
+}
+
+// defaultFetcher is used by the EngineAPI without a Fetcher
+var defaultFetcher = &Fetcher{}
+
+func (fetcher *Fetcher) maxBytes() int64 {
+	if fetcher.MaxBytes > 0 {
+		return fetcher.MaxBytes
+	}
+	return defaultMaxImageBytes
+}
+
+func (fetcher *Fetcher) checkURL(u *url.URL) error {
+	schemes := fetcher.AllowedSchemes
+	if len(schemes) == 0 {
+		schemes = defaultSchemes
+	}
+	if !containsFold(schemes, u.Scheme) {
+		return fmt.Errorf("%w: %q", errSchemeNotAllowed, u.Scheme)
+	}
+
+	host := strings.ToLower(u.Hostname())
+	if host == "" {
+		return errHostNotAllowed
+	}
+	if len(fetcher.AllowedHosts) == 0 {
+		return nil
+	}
+	for _, allowed := range fetcher.AllowedHosts {
+		allowed = strings.ToLower(allowed)
+		if host == allowed || (strings.HasPrefix(allowed, ".") && strings.HasSuffix(host, allowed)) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %q", errHostNotAllowed, host)
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func blockedIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() ||
+		sharedAddressSpace.Contains(ip)
+}
+
+// checkAddress is called with the resolved address of every connection,
+// hence a host name resolving to a blocked address is caught as well
+func (fetcher *Fetcher) checkAddress(network, address string, _ syscall.RawConn) error {
+	if fetcher.AllowPrivate {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || blockedIP(ip) {
+		return fmt.Errorf("%w: %s", errAddressBlocked, host)
+	}
+	return nil
+}
+
+func (fetcher *Fetcher) checkRedirect(req *http.Request, via []*http.Request) error {
+	maxRedirects := fetcher.MaxRedirects
+	if maxRedirects == 0 {
+		maxRedirects = defaultMaxRedirects
+	}
+	if len(via) > maxRedirects {
+		return errTooManyRedirects
+	}
+	return fetcher.checkURL(req.URL)
+}
+
+func (fetcher *Fetcher) httpClient() *http.Client {
+	fetcher.once.Do(func() {
+		timeout := fetcher.Timeout
+		if timeout <= 0 {
+			timeout = defaultFetchTimeout
+		}
+		dialer := &net.Dialer{Timeout: timeout, Control: fetcher.checkAddress}
+		fetcher.client = &http.Client{
+			Transport: &http.Transport{
+				// a proxy would connect to the blocked addresses on our behalf
+				Proxy:                 nil,
+				DialContext:           dialer.DialContext,
+				TLSHandshakeTimeout:   timeout,
+				ResponseHeaderTimeout: timeout,
+				MaxIdleConns:          16,
+				IdleConnTimeout:       90 * time.Second,
+			},
+			CheckRedirect: fetcher.checkRedirect,
+			Timeout:       timeout,
+		}
+	})
+	return fetcher.client
+}
+
+// Fetch downloads and decodes the image at `imageURL`, see decodeImage
+func (fetcher *Fetcher) Fetch(ctx context.Context, imageURL string) (image.Image, error) {
+	u, err := url.Parse(imageURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidURL, err)
+	}
+	if err := fetcher.checkURL(u); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "image/*")
+	resp, err := fetcher.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %s", errUnexpectedStatus, resp.Status)
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get(contentTypeKey))
+	if !strings.HasPrefix(mediaType, "image/") {
+		return nil, fmt.Errorf("%w: %q", errInvalidMediaType, mediaType)
+	}
+
+	maxBytes := fetcher.maxBytes()
+	if resp.ContentLength > maxBytes {
+		return nil, errImageTooLarge
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxBytes+1))
+	if err != nil {
+		return nil, &url.Error{Op: "Get", URL: u.String(), Err: err}
+	}
+	if int64(len(data)) > maxBytes {
+		return nil, errImageTooLarge
+	}
+
+	return decodeImage(bytes.NewReader(data), imageURL)
+}
@@ -0,0 +1,150 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/png"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func pngImage() []byte {
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewGray(image.Rect(0, 0, 32, 32)))
+	return buf.Bytes()
+}
+
+// imageServer serves a PNG image at /image, the redirects /redirect/n
+// leading to it after n hops, and a few misbehaving endpoints
+func imageServer(t *testing.T) *httptest.Server {
+	data := pngImage()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/image", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(contentTypeKey, "image/png")
+		w.Write(data)
+	})
+	mux.HandleFunc("/huge", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(contentTypeKey, "image/png")
+		w.Write(hugePNG(1<<16, 1<<16))
+	})
+	mux.HandleFunc("/html", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(contentTypeKey, "text/html")
+		w.Write(data)
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	})
+	mux.HandleFunc("/redirect/", func(w http.ResponseWriter, r *http.Request) {
+		hops, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/redirect/"))
+		if hops <= 1 {
+			http.Redirect(w, r, "/image", http.StatusFound)
+		} else {
+			http.Redirect(w, r, "/redirect/"+strconv.Itoa(hops-1), http.StatusFound)
+		}
+	})
+	mux.HandleFunc("/redirect-localhost", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, strings.Replace("http://"+r.Host, "127.0.0.1", "localhost", 1)+"/image", http.StatusFound)
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestFetch(t *testing.T) {
+	// arrange
+	server := imageServer(t)
+	fetcher := &Fetcher{AllowPrivate: true}
+
+	for _, path := range []string{"/image", "/redirect/3"} {
+		// act
+		img, err := fetcher.Fetch(context.Background(), server.URL+path)
+
+		// assert
+		if err != nil || img.Bounds().Dx() != 32 {
+			t.Errorf("Fetch(%s) = %v, want the image", path, err)
+		}
+	}
+}
+
+func TestFetchPolicy(t *testing.T) {
+	server := imageServer(t)
+
+	tests := []struct {
+		name    string
+		fetcher *Fetcher
+		url     string
+		want    error
+	}{
+		{"loopback", &Fetcher{}, server.URL + "/image", errAddressBlocked},
+		{"scheme", &Fetcher{AllowPrivate: true}, "file:///etc/passwd", errSchemeNotAllowed},
+		{"allowed schemes", &Fetcher{AllowPrivate: true, AllowedSchemes: []string{"https"}}, server.URL + "/image", errSchemeNotAllowed},
+		{"host", &Fetcher{AllowPrivate: true, AllowedHosts: []string{".example.com"}}, server.URL + "/image", errHostNotAllowed},
+		{"redirected host", &Fetcher{AllowPrivate: true, AllowedHosts: []string{"127.0.0.1"}}, server.URL + "/redirect-localhost", errHostNotAllowed},
+		{"redirects", &Fetcher{AllowPrivate: true}, server.URL + "/redirect/4", errTooManyRedirects},
+		{"no redirects", &Fetcher{AllowPrivate: true, MaxRedirects: -1}, server.URL + "/redirect/1", errTooManyRedirects},
+		{"media type", &Fetcher{AllowPrivate: true}, server.URL + "/html", errInvalidMediaType},
+		{"status", &Fetcher{AllowPrivate: true}, server.URL + "/missing", errUnexpectedStatus},
+		{"size", &Fetcher{AllowPrivate: true, MaxBytes: 16}, server.URL + "/image", errImageTooLarge},
+		{"pixels", &Fetcher{AllowPrivate: true}, server.URL + "/huge", errTooManyPixels},
+	}
+
+	for _, test := range tests {
+		// act
+		_, err := test.fetcher.Fetch(context.Background(), test.url)
+
+		// assert
+		if !errors.Is(err, test.want) {
+			t.Errorf("%s: Fetch() = %v, want %v", test.name, err, test.want)
+		}
+	}
+}
+
+func TestFetchTimeout(t *testing.T) {
+	// arrange
+	server := imageServer(t)
+	fetcher := &Fetcher{AllowPrivate: true, Timeout: 50 * time.Millisecond}
+
+	// act
+	start := time.Now()
+	_, err := fetcher.Fetch(context.Background(), server.URL+"/slow")
+
+	// assert
+	if err == nil || time.Since(start) > 5*time.Second {
+		t.Errorf("Fetch() = %v after %v, want a timeout", err, time.Since(start))
+	}
+}
+
+func TestBlockedIP(t *testing.T) {
+	tests := map[string]bool{
+		"127.0.0.1":       true,
+		"10.1.2.3":        true,
+		"172.16.0.1":      true,
+		"192.168.1.1":     true,
+		"169.254.169.254": true,
+		"100.64.0.1":      true,
+		"0.0.0.0":         true,
+		"::1":             true,
+		"fe80::1":         true,
+		"fc00::1":         true,
+		"::ffff:10.0.0.1": true,
+		"8.8.8.8":         false,
+		"2001:4860::8888": false,
+	}
+
+	for address, want := range tests {
+		// act
+		got := blockedIP(net.ParseIP(address))
+
+		// assert
+		if got != want {
+			t.Errorf("blockedIP(%s) = %v, want %v", address, got, want)
+		}
+	}
+}
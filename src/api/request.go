@@ -0,0 +1,251 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	engine "github.com/jx3yang/imgsearchengine/src/engine"
+	index "github.com/jx3yang/imgsearchengine/src/index"
+	vptree "github.com/jx3yang/imgsearchengine/src/vptree"
+)
+
+// hashValue is a PHash given either as a JSON number or as a JSON string,
+// which keeps all its bits in JavaScript clients, see EngineAPI.parseHash
+type hashValue string
+
+func (value *hashValue) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	if bytes.HasPrefix(data, []byte(`"`)) {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		*value = hashValue(s)
+		return nil
+	}
+	*value = hashValue(data)
+	return nil
+}
+
+// searchRequest is the schema of the search requests. The query image is
+// given by `image` or `phash`, or is uploaded, see queryHash. A KNN search
+// is run when K is set, a range search when Threshold is set, and a KNN
+// search limited to the neighbours within Threshold when both are set.
+type searchRequest struct {
+	K         *uint     `json:"k,omitempty"`
+	Threshold *float64  `json:"threshold,omitempty"`
+	Image     string    `json:"image,omitempty"`
+	PHash     hashValue `json:"phash,omitempty"`
+	// PHashes and Images are the other queries of the batch searches,
+	// in forms they are the repeated `phash` and `image` fields
+	PHashes []hashValue `json:"phashes,omitempty"`
+	Images  []string    `json:"images,omitempty"`
+	// Epsilon and Leaves make the KNN searches approximate, see vptree.SearchOptions
+	Epsilon float64 `json:"epsilon,omitempty"`
+	Leaves  int     `json:"leaves,omitempty"`
+	// Limit caps the number of results, 0 means no limit
+	Limit int `json:"limit,omitempty"`
+	// PathPrefix only keeps the images whose path starts with it
+	PathPrefix string `json:"pathPrefix,omitempty"`
+	// Dihedral also searches for the rotated and mirrored copies of the
+	// query image, keeping the smallest distance of each image found
+	Dihedral bool `json:"dihedral,omitempty"`
+}
+
+var (
+	errDihedralPHash = errors.New("The transforms need a query image, not a phash")
+	errNotFinite     = errors.New("Value must be finite")
+	errBatchOnly     = errors.New("Several queries need the batch search")
+)
+
+// parseSearchRequest reads the search request from the JSON body of `r`,
+// or from its form for the other media types. The `required` parameter
+// must be given, either k or threshold must be given otherwise. In forms,
+// the legacy `query` field stands for the `required` parameter, if any.
+func parseSearchRequest(r *http.Request, required string) (*searchRequest, *apiError) {
+	req := &searchRequest{}
+
+	if mediaType(r) == defaultContentType {
+		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(req); err != nil {
+			return nil, requestError(err)
+		}
+	} else if err := req.parseForm(r, required); err != nil {
+		return nil, err
+	}
+
+	return req, req.validate(required)
+}
+
+func (req *searchRequest) parseForm(r *http.Request, required string) *apiError {
+	values := map[string]string{
+		"k":         r.FormValue("k"),
+		"threshold": r.FormValue("threshold"),
+		"epsilon":   r.FormValue("epsilon"),
+		"leaves":    r.FormValue("leaves"),
+		"limit":     r.FormValue("limit"),
+		"dihedral":  r.FormValue("dihedral"),
+	}
+	if query := r.FormValue("query"); query != "" && required != "" {
+		values[required] = query
+	}
+
+	if k := values["k"]; k != "" {
+		value, err := strconv.ParseUint(k, 10, 64)
+		if err != nil {
+			return invalidParameter("k", err)
+		}
+		req.K = new(uint)
+		*req.K = uint(value)
+	}
+	if threshold := values["threshold"]; threshold != "" {
+		value, err := strconv.ParseFloat(threshold, 64)
+		if err != nil {
+			return invalidParameter("threshold", err)
+		}
+		req.Threshold = &value
+	}
+	if epsilon := values["epsilon"]; epsilon != "" {
+		value, err := strconv.ParseFloat(epsilon, 64)
+		if err != nil {
+			return invalidParameter("epsilon", err)
+		}
+		req.Epsilon = value
+	}
+	for _, field := range []string{"leaves", "limit"} {
+		if values[field] == "" {
+			continue
+		}
+		value, err := strconv.ParseUint(values[field], 10, 31)
+		if err != nil {
+			return invalidParameter(field, err)
+		}
+		if field == "leaves" {
+			req.Leaves = int(value)
+		} else {
+			req.Limit = int(value)
+		}
+	}
+
+	if dihedral := values["dihedral"]; dihedral != "" {
+		value, err := strconv.ParseBool(dihedral)
+		if err != nil {
+			return invalidParameter("dihedral", err)
+		}
+		req.Dihedral = value
+	}
+
+	if images := r.Form["image"]; len(images) > 0 {
+		req.Image, req.Images = images[0], images[1:]
+	}
+	if hashes := r.Form["phash"]; len(hashes) > 0 {
+		req.PHash = hashValue(hashes[0])
+		for _, hash := range hashes[1:] {
+			req.PHashes = append(req.PHashes, hashValue(hash))
+		}
+	}
+	req.PathPrefix = r.FormValue("pathPrefix")
+	return nil
+}
+
+func (req *searchRequest) validate(required string) *apiError {
+	switch {
+	case required == "k" && req.K == nil:
+		return missingParameter("k")
+	case required == "threshold" && req.Threshold == nil:
+		return missingParameter("threshold")
+	case req.K == nil && req.Threshold == nil:
+		return missingParameter("k")
+	case req.K != nil && *req.K < 1:
+		return invalidParameter("k", errors.New("k must be positive"))
+	case req.Threshold != nil && !finite(*req.Threshold):
+		return invalidParameter("threshold", errNotFinite)
+	case req.Threshold != nil && *req.Threshold < 0:
+		return invalidParameter("threshold", errors.New("threshold must be positive"))
+	case !finite(req.Epsilon):
+		return invalidParameter("epsilon", errNotFinite)
+	case req.Epsilon < 0:
+		return invalidParameter("epsilon", errors.New("epsilon must be positive"))
+	case req.Leaves < 0:
+		return invalidParameter("leaves", errors.New("leaves must be positive"))
+	case req.Limit < 0:
+		return invalidParameter("limit", errors.New("limit must be positive"))
+	}
+	return nil
+}
+
+func finite(value float64) bool {
+	return !math.IsNaN(value) && !math.IsInf(value, 0)
+}
+
+// single rejects the requests holding several queries, which
+// are only read by the batch searches, see EngineAPI.KNNBatchSearch
+func (req *searchRequest) single() *apiError {
+	switch {
+	case len(req.PHashes) > 0:
+		return invalidParameter("phashes", errBatchOnly)
+	case len(req.Images) > 0:
+		return invalidParameter("images", errBatchOnly)
+	}
+	return nil
+}
+
+// batchHashes returns the `phash` then the `phashes` of the request
+func (req *searchRequest) batchHashes() []hashValue {
+	if req.PHash == "" {
+		return req.PHashes
+	}
+	return append([]hashValue{req.PHash}, req.PHashes...)
+}
+
+// batchImages returns the `image` then the `images` of the request
+func (req *searchRequest) batchImages() []string {
+	if req.Image == "" {
+		return req.Images
+	}
+	return append([]string{req.Image}, req.Images...)
+}
+
+// options returns the options of the KNN searches within `budget`
+func (req *searchRequest) options(budget vptree.SearchOptions) vptree.SearchOptions {
+	opts := budget
+	opts.Epsilon = req.Epsilon
+	// the server budget cannot be raised by the client
+	if req.Leaves > 0 && (opts.MaxLeaves == 0 || req.Leaves < opts.MaxLeaves) {
+		opts.MaxLeaves = req.Leaves
+	}
+	return opts
+}
+
+// keep reports whether `result` passes the filters of the request
+func (req *searchRequest) keep(result index.Result[*engine.ImageInfo]) bool {
+	if req.Threshold != nil && result.Distance > *req.Threshold {
+		return false
+	}
+	return strings.HasPrefix(result.Point.GetPath(), req.PathPrefix)
+}
+
+func (req *searchRequest) filter(results []index.Result[*engine.ImageInfo]) []index.Result[*engine.ImageInfo] {
+	kept := results[:0]
+	for _, result := range results {
+		if req.keep(result) {
+			kept = append(kept, result)
+		}
+	}
+	return kept
+}
+
+func (req *searchRequest) limit(results []index.Result[*engine.ImageInfo]) []index.Result[*engine.ImageInfo] {
+	if req.Limit > 0 && len(results) > req.Limit {
+		return results[:req.Limit]
+	}
+	return results
+}
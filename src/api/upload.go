@@ -0,0 +1,164 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strings"
+
+	engine "github.com/jx3yang/imgsearchengine/src/engine"
+)
+
+// defaultMaxUploadSize is the default size limit of the requests, in bytes
+const defaultMaxUploadSize = 10 << 20
+
+// maxImagePixels is the limit of the number of pixels of the decoded images,
+// since a small file can claim dimensions needing gigabytes once decoded
+const maxImagePixels = 32 << 20
+
+// uploadField is the multipart field holding the uploaded images
+const uploadField = "file"
+
+// imageMediaTypes are the media types of the images which can be decoded
+var imageMediaTypes = []string{"image/png", "image/jpeg"}
+
+var (
+	errBodyTooLarge         = errors.New("Request body too large")
+	errUnsupportedMediaType = errors.New("Unsupported media type")
+	errTooManyPixels        = errors.New("Image has too many pixels")
+)
+
+func (service *EngineAPI) maxUploadSize() int64 {
+	if service.MaxUploadSize > 0 {
+		return service.MaxUploadSize
+	}
+	return defaultMaxUploadSize
+}
+
+func mediaType(r *http.Request) string {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get(contentTypeKey))
+	return mediaType
+}
+
+// supportedMediaType reports whether the body of a request
+// of media type `mediaType` can be read
+func supportedMediaType(mediaType string) bool {
+	switch mediaType {
+	case "", defaultContentType, "application/x-www-form-urlencoded", "multipart/form-data":
+		return true
+	}
+	for _, imageType := range imageMediaTypes {
+		if mediaType == imageType {
+			return true
+		}
+	}
+	return false
+}
+
+// parseRequest limits the size of the body of the request, and parses
+// its form, keeping the uploaded images in memory
+func (service *EngineAPI) parseRequest(w http.ResponseWriter, r *http.Request) error {
+	if !supportedMediaType(mediaType(r)) {
+		return errUnsupportedMediaType
+	}
+	limit := service.maxUploadSize()
+	if r.ContentLength > limit {
+		return errBodyTooLarge
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, limit)
+
+	if mediaType(r) == "multipart/form-data" {
+		return r.ParseMultipartForm(limit)
+	}
+	// the body of the other media types is left for the raw images
+	return r.ParseForm()
+}
+
+// decodeImage decodes the image read from `r`, unless it has more than
+// maxImagePixels pixels, the errors name its `path` if any
+func decodeImage(r io.Reader, path string) (image.Image, error) {
+	// the header read to check the dimensions is read again by image.Decode
+	var header bytes.Buffer
+	config, _, err := image.DecodeConfig(io.TeeReader(r, &header))
+	if err != nil {
+		return nil, pathError("decode", path, err)
+	}
+	if int64(config.Width)*int64(config.Height) > maxImagePixels {
+		return nil, pathError("decode", path, fmt.Errorf("%w: %dx%d", errTooManyPixels, config.Width, config.Height))
+	}
+
+	img, _, err := image.Decode(io.MultiReader(&header, r))
+	if err != nil {
+		return nil, pathError("decode", path, err)
+	}
+	return img, nil
+}
+
+func uploadImage(upload *multipart.FileHeader) (image.Image, error) {
+	file, err := upload.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return decodeImage(file, upload.Filename)
+}
+
+// uploads returns the images uploaded in the multipart form of the request
+func uploads(r *http.Request) []*multipart.FileHeader {
+	if r.MultipartForm == nil {
+		return nil
+	}
+	return r.MultipartForm.File[uploadField]
+}
+
+// queryPoints returns the query points of the search request `req`, the
+// point of the query image, or the points of its 8 transforms if
+// `dihedral` is set, see EngineAPI.points. The query image is in order
+// of precedence
+//   - the image uploaded in the `file` field of a multipart form,
+//   - the image sent as the body of the request, with the media type of a
+//     PNG or JPEG image,
+//     the parameters then being given in the URL,
+//   - the `phash` parameter, see EngineAPI.parseHash,
+//   - the image found at the `image` URL.
+func (service *EngineAPI) queryPoints(r *http.Request, req *searchRequest) ([]*engine.ImageInfo, error) {
+	var img image.Image
+	var path, field string
+	var err error
+
+	if files := uploads(r); len(files) > 0 {
+		path, field = files[0].Filename, uploadField
+		img, err = uploadImage(files[0])
+	} else if strings.HasPrefix(mediaType(r), "image/") {
+		field = "body"
+		img, err = decodeImage(r.Body, "")
+	} else if req.PHash != "" {
+		if req.Dihedral {
+			return nil, invalidParameter("dihedral", errDihedralPHash)
+		}
+		point, err := service.parseHash(string(req.PHash))
+		if err != nil {
+			return nil, invalidParameter("phash", err)
+		}
+		return []*engine.ImageInfo{point}, nil
+	} else if req.Image == "" {
+		return nil, missingParameter("image")
+	} else {
+		path, field = req.Image, "image"
+		img, err = service.fetchImage(r.Context(), req.Image)
+	}
+	if err != nil {
+		return nil, imageError(field, err)
+	}
+
+	points, err := service.points(img, path, req.Dihedral)
+	if err != nil {
+		return nil, imageError(field, err)
+	}
+	return points, nil
+}
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"io"
+	"math/rand"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	engine "github.com/jx3yang/imgsearchengine/src/engine"
+	index "github.com/jx3yang/imgsearchengine/src/index"
+	phash "github.com/jx3yang/imgsearchengine/src/phash"
+)
+
+// hugePNG returns a PNG image claiming `width`x`height` pixels, with no data
+func hugePNG(width, height uint32) []byte {
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewGray(image.Rect(0, 0, 1, 1)))
+	data := buf.Bytes()
+	// the IHDR chunk follows the 8 bytes signature, its data starting with the dimensions
+	binary.BigEndian.PutUint32(data[16:], width)
+	binary.BigEndian.PutUint32(data[20:], height)
+	binary.BigEndian.PutUint32(data[29:], crc32.ChecksumIEEE(data[12:29]))
+	return data
+}
+
+// multipartBody returns the multipart form uploading `data` with k=1
+func multipartBody(data []byte) (*bytes.Buffer, string) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, _ := writer.CreateFormFile(uploadField, "upload.png")
+	part.Write(data)
+	writer.WriteField("k", "1")
+	writer.Close()
+	return &body, writer.FormDataContentType()
+}
+
+// chunked hides the length of `data`, as a chunked request body would
+type chunked struct {
+	io.Reader
+}
+
+func TestSearchUpload(t *testing.T) {
+	// arrange
+	grayHash, _ := phash.GetPHash(image.NewGray(image.Rect(0, 0, 32, 32)))
+	points := []*engine.ImageInfo{
+		engine.NewImageInfo(grayHash, "gray.png"),
+		engine.NewImageInfo(^grayHash, "other.png"),
+	}
+	service := &EngineAPI{Index: index.NewLinear(points, engine.HashDistance), MaxUploadSize: 1 << 10}
+
+	// random pixels do not compress below the size limit
+	noise := image.NewGray(image.Rect(0, 0, 64, 64))
+	rand.New(rand.NewSource(1)).Read(noise.Pix)
+	var largeBuf bytes.Buffer
+	png.Encode(&largeBuf, noise)
+	large := largeBuf.Bytes()
+	multipartData, multipartType := multipartBody(pngImage())
+	largeMultipart, largeMultipartType := multipartBody(large)
+	hugeMultipart, hugeMultipartType := multipartBody(hugePNG(1<<16, 1<<16))
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        io.Reader
+		status      int
+		code        string
+	}{
+		{"multipart", multipartType, multipartData, http.StatusOK, ""},
+		{"raw", "image/png", bytes.NewReader(pngImage()), http.StatusOK, ""},
+		{"large raw", "image/png", bytes.NewReader(large), http.StatusRequestEntityTooLarge, codeRequestTooLarge},
+		{"chunked raw", "image/png", chunked{bytes.NewReader(large)}, http.StatusRequestEntityTooLarge, codeRequestTooLarge},
+		{"chunked multipart", largeMultipartType, chunked{largeMultipart}, http.StatusRequestEntityTooLarge, codeRequestTooLarge},
+		{"huge raw", "image/png", bytes.NewReader(hugePNG(1<<16, 1<<16)), http.StatusRequestEntityTooLarge, codeRequestTooLarge},
+		{"huge multipart", hugeMultipartType, hugeMultipart, http.StatusRequestEntityTooLarge, codeRequestTooLarge},
+		{"text", "text/plain", bytes.NewReader([]byte("gray.png")), http.StatusUnsupportedMediaType, codeUnsupportedMedia},
+		{"gif", "image/gif", bytes.NewReader(pngImage()), http.StatusUnsupportedMediaType, codeUnsupportedMedia},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/?k=1", test.body)
+		req.Header.Set(contentTypeKey, test.contentType)
+		w := httptest.NewRecorder()
+
+		// act
+		service.Search(w, req)
+
+		// assert
+		if w.Code != test.status {
+			t.Errorf("%s: Search() = %d %s, want %d", test.name, w.Code, w.Body.String(), test.status)
+			continue
+		}
+		if test.status == http.StatusOK {
+			if got := paths(t, w); len(got) != 1 || got[0] != "gray.png" {
+				t.Errorf("%s: Search() = %v, want gray.png", test.name, got)
+			}
+		} else if got := decodeError(t, w); got.Code != test.code {
+			t.Errorf("%s: Search() = %+v, want code %q", test.name, got, test.code)
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package bktree
+
+import (
+	"container/heap"
+	"errors"
+	"sync"
+
+	index "github.com/jx3yang/imgsearchengine/src/index"
+	phash "github.com/jx3yang/imgsearchengine/src/phash"
+)
+
+var _ index.SearchIndex[int] = (*BKTree[int])(nil)
+
+// BKNode is a node in a BKTree
+type BKNode[T comparable] struct {
+	// Points holds all the points whose PHash is Hash, hence the
+	// near-duplicate images do not make the tree deeper. A node whose
+	// points were all deleted is still used for routing.
+	Points []T
+	Hash   phash.PHash
+	// Children maps the Hamming distance to this node
+	// to the child holding the points at that distance
+	Children map[int]*BKNode[T]
+}
+
+// BKTree implements the Burkhard-Keller Tree over the Hamming
+// distance between the PHashes of the points
+type BKTree[T comparable] struct {
+	Root     *BKNode[T]
+	key      func(T) phash.PHash
+	tieBreak func(point1, point2 T) bool
+	size     int
+	mu       sync.RWMutex
+}
+
+// New returns an empty BK-Tree, where `key` returns the PHash of a point
+func New[T comparable](key func(T) phash.PHash) *BKTree[T] {
+	return &BKTree[T]{key: key}
+}
+
+// BuildTree will return the BK-Tree holding `points`
+func BuildTree[T comparable](points []T, key func(T) phash.PHash) *BKTree[T] {
+	tree := New(key)
+	for _, point := range points {
+		tree.insert(point)
+	}
+	return tree
+}
+
+// SetTieBreaker sets the function ordering the points at the same distance
+// from the query, which otherwise come in the order the tree was traversed
+func (tree *BKTree[T]) SetTieBreaker(less func(point1, point2 T) bool) {
+	tree.mu.Lock()
+	defer tree.mu.Unlock()
+	tree.tieBreak = less
+}
+
+func (tree *BKTree[T]) insert(point T) {
+	tree.size++
+	hash := tree.key(point)
+	node := &BKNode[T]{Points: []T{point}, Hash: hash}
+
+	if tree.Root == nil {
+		tree.Root = node
+		return
+	}
+
+	currentNode := tree.Root
+	for {
+		dist := phash.HammingDist(currentNode.Hash, hash)
+		if dist == 0 {
+			currentNode.Points = append(currentNode.Points, point)
+			return
+		}
+		child, ok := currentNode.Children[dist]
+		if !ok {
+			if currentNode.Children == nil {
+				currentNode.Children = make(map[int]*BKNode[T])
+			}
+			currentNode.Children[dist] = node
+			return
+		}
+		currentNode = child
+	}
+}
+
+// Insert adds `point` to the BK-Tree
+func (tree *BKTree[T]) Insert(point T) {
+	tree.mu.Lock()
+	defer tree.mu.Unlock()
+	tree.insert(point)
+}
+
+// Delete removes `point` from the BK-Tree and reports whether it was
+// found. A node left without points is kept, since its children are
+// placed according to their distance to it.
+func (tree *BKTree[T]) Delete(point T) bool {
+	tree.mu.Lock()
+	defer tree.mu.Unlock()
+
+	hash := tree.key(point)
+	currentNode := tree.Root
+	for currentNode != nil {
+		dist := phash.HammingDist(currentNode.Hash, hash)
+		if dist > 0 {
+			// a point with the same hash is always stored in the child
+			// at the distance of that hash
+			currentNode = currentNode.Children[dist]
+			continue
+		}
+		for i, p := range currentNode.Points {
+			if p == point {
+				currentNode.Points = append(currentNode.Points[:i], currentNode.Points[i+1:]...)
+				tree.size--
+				return true
+			}
+		}
+		return false
+	}
+	return false
+}
+
+// Len returns the number of points in the BK-Tree which have not been deleted
+func (tree *BKTree[T]) Len() int {
+	tree.mu.RLock()
+	defer tree.mu.RUnlock()
+	return tree.size
+}
+
+// Ready reports whether the BK-Tree holds any point
+func (tree *BKTree[T]) Ready() bool {
+	return tree.Len() > 0
+}
+
+// RangeSearch will return all the points within a `threshold` normalized
+// Hamming distance from the given `point`, sorted by ascending distance
+func (tree *BKTree[T]) RangeSearch(point T, threshold float64) ([]index.Result[T], error) {
+	if threshold < 0 {
+		return nil, errors.New("Threshold must be positive")
+	}
+	tree.mu.RLock()
+	defer tree.mu.RUnlock()
+
+	hash := tree.key(point)
+	radius := phash.ThresholdBits(threshold)
+	results := make([]index.Result[T], 0)
+
+	nodesToVisit := make([]*BKNode[T], 0)
+	if tree.Root != nil {
+		nodesToVisit = append(nodesToVisit, tree.Root)
+	}
+
+	for len(nodesToVisit) > 0 {
+		currentNode := nodesToVisit[len(nodesToVisit)-1]
+		nodesToVisit = nodesToVisit[:len(nodesToVisit)-1]
+
+		dist := phash.HammingDist(currentNode.Hash, hash)
+		if dist <= radius {
+			for _, p := range currentNode.Points {
+				results = append(results, index.Result[T]{Point: p, Distance: float64(dist) / phash.Bits})
+			}
+		}
+
+		// by the triangle inequality, only the children at a distance
+		// within `radius` of `dist` can hold points within range
+		for childDist, child := range currentNode.Children {
+			if dist-radius <= childDist && childDist <= dist+radius {
+				nodesToVisit = append(nodesToVisit, child)
+			}
+		}
+	}
+
+	index.SortResults(results, tree.tieBreak)
+	return results, nil
+}
+
+type neighbour[T comparable] struct {
+	point T
+	dist  int
+}
+
+// neighbours is a max-heap of the nearest neighbours found so far
+type neighbours[T comparable] struct {
+	items    []neighbour[T]
+	tieBreak func(point1, point2 T) bool
+}
+
+// closer reports whether `n1` comes before `n2` in the results
+func (h *neighbours[T]) closer(n1, n2 neighbour[T]) bool {
+	if n1.dist != n2.dist || h.tieBreak == nil {
+		return n1.dist < n2.dist
+	}
+	return h.tieBreak(n1.point, n2.point)
+}
+
+func (h *neighbours[T]) Len() int { return len(h.items) }
+
+func (h *neighbours[T]) Less(i, j int) bool { return h.closer(h.items[j], h.items[i]) }
+
+func (h *neighbours[T]) Swap(i, j int) { h.items[i], h.items[j] = h.items[j], h.items[i] }
+
+func (h *neighbours[T]) Push(x interface{}) { h.items = append(h.items, x.(neighbour[T])) }
+
+func (h *neighbours[T]) Pop() interface{} {
+	last := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return last
+}
+
+// KNNSearch will return the k nearest neighbours of the given `point`
+// in the BK-Tree, sorted by ascending distance
+func (tree *BKTree[T]) KNNSearch(point T, k uint) ([]index.Result[T], error) {
+	if k < 1 {
+		return nil, errors.New("Invalid k")
+	}
+	tree.mu.RLock()
+	defer tree.mu.RUnlock()
+
+	hash := tree.key(point)
+	results := &neighbours[T]{tieBreak: tree.tieBreak}
+	// the radius shrinks to the distance of the kth neighbour once found
+	radius := phash.Bits
+
+	var visit func(*BKNode[T])
+	visit = func(currentNode *BKNode[T]) {
+		dist := phash.HammingDist(currentNode.Hash, hash)
+		for _, p := range currentNode.Points {
+			if dist > radius {
+				break
+			}
+			candidate := neighbour[T]{p, dist}
+			if uint(results.Len()) < k {
+				heap.Push(results, candidate)
+			} else if results.closer(candidate, results.items[0]) {
+				results.items[0] = candidate
+				heap.Fix(results, 0)
+			}
+			if uint(results.Len()) == k {
+				radius = results.items[0].dist
+			}
+		}
+
+		// the children closest to `dist` are the most likely to
+		// hold close points, hence they are visited first
+		for offset := 0; offset <= radius; offset++ {
+			if child, ok := currentNode.Children[dist-offset]; ok {
+				visit(child)
+			}
+			// the radius may have shrunk while visiting the previous child
+			if child, ok := currentNode.Children[dist+offset]; ok && offset > 0 && offset <= radius {
+				visit(child)
+			}
+		}
+	}
+
+	if tree.Root != nil {
+		visit(tree.Root)
+	}
+
+	knnResults := make([]index.Result[T], 0, results.Len())
+	for _, item := range results.items {
+		knnResults = append(knnResults, index.Result[T]{Point: item.point, Distance: float64(item.dist) / phash.Bits})
+	}
+	index.SortResults(knnResults, tree.tieBreak)
+
+	return knnResults, nil
+}
@@ -0,0 +1,149 @@
+package bktree
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	index "github.com/jx3yang/imgsearchengine/src/index"
+	hashtest "github.com/jx3yang/imgsearchengine/src/internal/hashtest"
+	phash "github.com/jx3yang/imgsearchengine/src/phash"
+	vptree "github.com/jx3yang/imgsearchengine/src/vptree"
+)
+
+func TestKNNSearch(t *testing.T) {
+	// arrange
+	r := rand.New(rand.NewSource(1))
+	points := hashtest.ClusteredHashes(3000, r)
+	reference := hashtest.Reference(points)
+	k := 10
+
+	tree := BuildTree(points, hashtest.Identity)
+	tree.SetTieBreaker(hashtest.Less)
+
+	for i := 0; i < 20; i++ {
+		point := points[r.Intn(len(points))] ^ phash.PHash(r.Uint64()&r.Uint64()&r.Uint64())
+
+		// act
+		got, _ := tree.KNNSearch(point, uint(k))
+
+		// assert
+		want, _ := reference.KNNSearch(point, uint(k))
+		if !reflect.DeepEqual(want, got) {
+			t.Errorf("KNNSearch() = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestRangeSearch(t *testing.T) {
+	// arrange
+	r := rand.New(rand.NewSource(2))
+	points := hashtest.ClusteredHashes(3000, r)
+	reference := hashtest.Reference(points)
+	// the thresholds next to a number of bits include the same points as the reference
+	thresholds := []float64{0.1, math.Nextafter(6./64, 0), 6. / 64}
+
+	tree := BuildTree(points, hashtest.Identity)
+	tree.SetTieBreaker(hashtest.Less)
+
+	for i := 0; i < 30; i++ {
+		point := points[r.Intn(len(points))]
+		threshold := thresholds[i%len(thresholds)]
+
+		// act
+		got, _ := tree.RangeSearch(point, threshold)
+
+		// assert
+		want, _ := reference.RangeSearch(point, threshold)
+		if !reflect.DeepEqual(want, got) {
+			t.Errorf("RangeSearch(%v) = %v, want %v", threshold, got, want)
+		}
+	}
+}
+
+func TestDelete(t *testing.T) {
+	// arrange
+	points := []phash.PHash{0b1011, 0b1001, 0b0001, 0b1111, 0b0000}
+	tree := BuildTree(points, hashtest.Identity)
+	tree.SetTieBreaker(hashtest.Less)
+
+	want := []index.Result[phash.PHash]{
+		{Point: 0b1001, Distance: 1. / 64},
+		{Point: 0b1111, Distance: 1. / 64},
+	}
+
+	// act
+	deleted := tree.Delete(0b1011)
+	deletedAgain := tree.Delete(0b1011)
+	got, _ := tree.KNNSearch(0b1011, 2)
+
+	// assert
+	if !deleted || deletedAgain {
+		t.Errorf("Delete() = %v then %v, want true then false", deleted, deletedAgain)
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("KNNSearch() = %v, want %v", got, want)
+	}
+	if tree.Len() != len(points)-1 {
+		t.Errorf("Len() = %d, want %d", tree.Len(), len(points)-1)
+	}
+}
+
+func TestDuplicates(t *testing.T) {
+	// arrange
+	type image struct {
+		hash phash.PHash
+		id   int
+	}
+	points := make([]image, 0)
+	for id := 0; id < 1000; id++ {
+		points = append(points, image{hash: 0b1011, id: id})
+	}
+	points = append(points, image{hash: 0b0011, id: 1000})
+	tree := BuildTree(points, func(img image) phash.PHash { return img.hash })
+	tree.SetTieBreaker(func(img1, img2 image) bool { return img1.id < img2.id })
+
+	// act
+	deleted := tree.Delete(points[0])
+	got, _ := tree.KNNSearch(image{hash: 0b1011}, 2)
+	gotRange, _ := tree.RangeSearch(image{hash: 0b0011}, 1./64)
+
+	// assert
+	if len(tree.Root.Points) != 999 || len(tree.Root.Children) != 1 {
+		t.Errorf("the root holds %d points and %d children, want the 999 duplicates and 1 child", len(tree.Root.Points), len(tree.Root.Children))
+	}
+	want := []index.Result[image]{{Point: points[1]}, {Point: points[2]}}
+	if !deleted || !reflect.DeepEqual(want, got) {
+		t.Errorf("KNNSearch() = %v after Delete() = %v, want %v", got, deleted, want)
+	}
+	if len(gotRange) != 1000 || gotRange[0].Point != points[1000] {
+		t.Errorf("RangeSearch() = %d results starting with %v, want 1000 starting with %v", len(gotRange), gotRange[0], points[1000])
+	}
+}
+
+// BenchmarkKNNSearch compares the BK-Tree to the VP-Tree on the same hashes
+func BenchmarkKNNSearch(b *testing.B) {
+	r := rand.New(rand.NewSource(1))
+	points := hashtest.ClusteredHashes(100000, r)
+	queries := make([]phash.PHash, 200)
+	for i := range queries {
+		queries[i] = points[r.Intn(len(points))] ^ 1<<uint(r.Intn(64))
+	}
+	k := uint(10)
+
+	indexes := []struct {
+		name  string
+		index index.SearchIndex[phash.PHash]
+	}{
+		{"bktree", BuildTree(points, hashtest.Identity)},
+		{"vptree", vptree.BuildTree(append([]phash.PHash(nil), points...), phash.NormHammingDist)},
+	}
+	for _, idx := range indexes {
+		b.Run(idx.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				idx.index.KNNSearch(queries[i%len(queries)], k)
+			}
+		})
+	}
+}
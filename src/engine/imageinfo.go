@@ -4,11 +4,15 @@ import phash "github.com/jx3yang/imgsearchengine/src/phash"
 
 // ImageInfo contains the PHash as well as the path of an image
 type ImageInfo struct {
-	hash phash.PHash
-	path string
+	hash      phash.PHash
+	ext       phash.ExtPHash
+	regions   []phash.PHash
+	algorithm phash.Algorithm
+	path      string
 }
 
-// NewImageInfo returns a struct containing the hash and path of the image
+// NewImageInfo returns a struct containing the hash and path of the image,
+// where the hash was computed by phash.GetPHash
 func NewImageInfo(hash phash.PHash, path string) *ImageInfo {
 	return &ImageInfo{
 		hash: hash,
@@ -16,8 +20,65 @@ func NewImageInfo(hash phash.PHash, path string) *ImageInfo {
 	}
 }
 
-// GetPHash returns the PHash of the associated image
+// NewImageInfoWithAlgorithm returns a struct containing the hash of the
+// image computed by the given algorithm, and its path
+func NewImageInfoWithAlgorithm(hash phash.PHash, algorithm phash.Algorithm, path string) *ImageInfo {
+	return &ImageInfo{
+		hash:      hash,
+		algorithm: algorithm,
+		path:      path,
+	}
+}
+
+// NewExtImageInfo returns a struct containing the extended hash of the
+// image computed by phash.GetExtPHash, and its path
+func NewExtImageInfo(hash phash.ExtPHash, path string) *ImageInfo {
+	return &ImageInfo{
+		ext:  hash,
+		path: path,
+	}
+}
+
+// NewRegionImageInfo returns a struct containing the crop resistant hashes
+// of the image computed by phash.CropResistantHashes, whose first one is
+// the PHash of the whole image, and its path
+func NewRegionImageInfo(hashes []phash.PHash, algorithm phash.Algorithm, path string) *ImageInfo {
+	imgInfo := &ImageInfo{algorithm: algorithm, path: path}
+	if len(hashes) > 0 {
+		imgInfo.hash = hashes[0]
+		imgInfo.regions = hashes[1:]
+	}
+	return imgInfo
+}
+
+// GetPHash returns the PHash of the associated image, it is 0 for the
+// images with an extended hash
 func (imgInfo *ImageInfo) GetPHash() phash.PHash { return imgInfo.hash }
 
+// GetExtPHash returns the extended hash of the associated image, if any
+func (imgInfo *ImageInfo) GetExtPHash() phash.ExtPHash { return imgInfo.ext }
+
+// GetRegions returns the PHashes of the regions of the image, if any
+func (imgInfo *ImageInfo) GetRegions() []phash.PHash { return imgInfo.regions }
+
+// IsExtended reports whether the image has an extended hash
+func (imgInfo *ImageInfo) IsExtended() bool { return imgInfo.ext != nil }
+
+// GetBits returns the number of bits of the hash of the image
+func (imgInfo *ImageInfo) GetBits() int {
+	if imgInfo.IsExtended() {
+		return imgInfo.ext.Bits()
+	}
+	return phash.Bits
+}
+
+// GetAlgorithm returns the algorithm which computed the PHash
+func (imgInfo *ImageInfo) GetAlgorithm() phash.Algorithm { return imgInfo.algorithm }
+
 // GetPath returns the path of the associated image
 func (imgInfo *ImageInfo) GetPath() string { return imgInfo.path }
+
+// allHashes returns the PHash of the image followed by those of its regions
+func (imgInfo *ImageInfo) allHashes() []phash.PHash {
+	return append([]phash.PHash{imgInfo.hash}, imgInfo.regions...)
+}
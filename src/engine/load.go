@@ -3,27 +3,77 @@ package engine
 import (
 	"encoding/csv"
 	"errors"
+	"fmt"
 	"image"
 	"io"
 	"log"
 	"os"
 	"strconv"
+	"strings"
 
 	// for decoding
 	_ "image/jpeg"
 	_ "image/png"
 
+	bktree "github.com/jx3yang/imgsearchengine/src/bktree"
+	index "github.com/jx3yang/imgsearchengine/src/index"
+	mih "github.com/jx3yang/imgsearchengine/src/mih"
 	phash "github.com/jx3yang/imgsearchengine/src/phash"
 	vptree "github.com/jx3yang/imgsearchengine/src/vptree"
 )
 
 const (
-	pathCol  string = "path"
-	phashCol string = "phash"
+	pathCol      string = "path"
+	phashCol     string = "phash"
+	algorithmCol string = "algorithm"
+	regionsCol   string = "regions"
 )
 
-func distanceFnc(img1, img2 interface{}) float64 {
-	return phash.NormHammingDist(img1.(*ImageInfo).GetPHash(), img2.(*ImageInfo).GetPHash())
+// columns holds the indexes of the columns of a CSV file, the
+// optional columns missing from the file have a negative index
+type columns struct {
+	path      int
+	phash     int
+	algorithm int
+	regions   int
+}
+
+// HashDistance is the distance between the images of the VP-Trees, which
+// compares the extended hashes of the images if any, an image with a PHash
+// is then at the maximum distance 1 from the other ones, as are the images
+// whose hashes were computed by different algorithms
+func HashDistance(img1, img2 *ImageInfo) float64 {
+	if img1.algorithm != img2.algorithm {
+		return 1
+	}
+	if img1.IsExtended() || img2.IsExtended() {
+		return phash.NormExtHammingDist(img1.GetExtPHash(), img2.GetExtPHash())
+	}
+	return phash.NormHammingDist(img1.GetPHash(), img2.GetPHash())
+}
+
+// RegionDistance is the distance between the images with crop resistant
+// hashes, see NewRegionImageInfo, which is the distance between their best
+// matching regions, the whole images included. It is not a metric, hence
+// it only suits the indexes comparing the query to every image, such as
+// index.Linear. The images hashed by different algorithms are at distance 1.
+func RegionDistance(img1, img2 *ImageInfo) float64 {
+	if img1.algorithm != img2.algorithm {
+		return 1
+	}
+	return phash.MinRegionDist(img1.allHashes(), img2.allHashes())
+}
+
+// tieBreakFnc orders the images at the same distance by path, then by hash
+func tieBreakFnc(img1, img2 *ImageInfo) bool {
+	if img1.GetPath() != img2.GetPath() {
+		return img1.GetPath() < img2.GetPath()
+	}
+	return img1.GetPHash() < img2.GetPHash()
+}
+
+func hashFnc(img *ImageInfo) phash.PHash {
+	return img.GetPHash()
 }
 
 func processCSV(rc io.Reader, sep rune) (<-chan []string, <-chan []string) {
@@ -56,110 +106,362 @@ func processCSV(rc io.Reader, sep rune) (<-chan []string, <-chan []string) {
 	return headch, ch
 }
 
-func processEntries(ch <-chan []string, pathIdx int, phashIdx int) <-chan *ImageInfo {
+// imageHasher returns the ImageInfo of the image `img` at `path`
+type imageHasher func(img image.Image, path string) (*ImageInfo, error)
+
+func withHasher(hasher phash.Hasher) imageHasher {
+	return func(img image.Image, path string) (*ImageInfo, error) {
+		hash, err := hasher.Hash(img)
+		if err != nil {
+			return nil, err
+		}
+		return NewImageInfoWithAlgorithm(hash, hasher.Algorithm(), path), nil
+	}
+}
+
+func withExtBits(nbits int) imageHasher {
+	return func(img image.Image, path string) (*ImageInfo, error) {
+		hash, err := phash.GetExtPHash(img, nbits)
+		if err != nil {
+			return nil, err
+		}
+		return NewExtImageInfo(hash, path), nil
+	}
+}
+
+func withRegions(hasher phash.Hasher) imageHasher {
+	return func(img image.Image, path string) (*ImageInfo, error) {
+		hashes, err := phash.CropResistantHashes(img, hasher)
+		if err != nil {
+			return nil, err
+		}
+		return NewRegionImageInfo(hashes, hasher.Algorithm(), path), nil
+	}
+}
+
+// parseRegions parses the PHashes of the regions of an image,
+// written in decimal and separated by spaces
+func parseRegions(s string) ([]phash.PHash, error) {
+	fields := strings.Fields(s)
+	regions := make([]phash.PHash, len(fields))
+	for i, field := range fields {
+		n, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		regions[i] = phash.PHash(n)
+	}
+	return regions, nil
+}
+
+// formatRegions is the inverse of parseRegions
+func formatRegions(imgInfo *ImageInfo) string {
+	fields := make([]string, len(imgInfo.regions))
+	for i, region := range imgInfo.regions {
+		fields[i] = strconv.FormatUint(uint64(region), 10)
+	}
+	return strings.Join(fields, " ")
+}
+
+// parseHash parses the PHashes written in decimal, and the extended
+// hashes written in hexadecimal, including those of 64 bits, see
+// phash.ParseExt
+func parseHash(s string) (phash.PHash, phash.ExtPHash, error) {
+	if n, err := strconv.ParseUint(s, 10, 64); err == nil {
+		return phash.PHash(n), nil, nil
+	}
+	ext, err := phash.ParseExt(s)
+	if err != nil {
+		return 0, nil, err
+	}
+	return 0, ext, nil
+}
+
+// formatHash is the inverse of parseHash
+func formatHash(imgInfo *ImageInfo) string {
+	if imgInfo.IsExtended() {
+		return imgInfo.ext.String()
+	}
+	return strconv.FormatUint(uint64(imgInfo.hash), 10)
+}
+
+// processEntry returns the image of the CSV row `elem`, see processEntries
+func processEntry(elem []string, cols columns, hashImage imageHasher) (*ImageInfo, error) {
+	path := elem[cols.path]
+	if cols.phash < 0 {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read %s: %w", path, err)
+		}
+		defer file.Close()
+		img, _, err := image.Decode(file)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to decode %s: %w", path, err)
+		}
+		imgInfo, err := hashImage(img, path)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to hash %s: %w", path, err)
+		}
+		return imgInfo, nil
+	}
+
+	hash, ext, err := parseHash(elem[cols.phash])
+	if err != nil {
+		return nil, fmt.Errorf("Image with path %s has invalid PHash: %w", path, err)
+	}
+	algorithm := phash.Perception
+	if cols.algorithm >= 0 && elem[cols.algorithm] != "" {
+		if algorithm, err = phash.ParseAlgorithm(elem[cols.algorithm]); err != nil {
+			return nil, fmt.Errorf("Image with path %s has invalid algorithm: %w", path, err)
+		}
+	}
+	var regions []phash.PHash
+	if cols.regions >= 0 && elem[cols.regions] != "" {
+		if regions, err = parseRegions(elem[cols.regions]); err != nil {
+			return nil, fmt.Errorf("Image with path %s has invalid regions: %w", path, err)
+		}
+	}
+	return &ImageInfo{hash: hash, ext: ext, regions: regions, algorithm: algorithm, path: path}, nil
+}
+
+// processEntries reads the PHashes of the images, and their algorithm and
+// the PHashes of their regions if any, or computes them with `hashImage`
+// when there is no phash column. It stops at the first image failing,
+// whose error is sent on the returned error channel.
+func processEntries(ch <-chan []string, cols columns, hashImage imageHasher) (<-chan *ImageInfo, <-chan error) {
 	imgCh := make(chan *ImageInfo)
-	computePHash := phashIdx < 0
+	errCh := make(chan error, 1)
 
 	go func() {
 		defer close(imgCh)
+		defer close(errCh)
 		for elem := range ch {
-			path := elem[pathIdx]
-			var hash phash.PHash
-			if computePHash {
-				file, err := os.Open(path)
-				if err != nil {
-					log.Fatal("Unabled to read ", path)
-				}
-				img, _, _ := image.Decode(file)
-				hash = phash.GetPHash(img)
-				file.Close()
-			} else {
-				n, err := strconv.ParseUint(elem[phashIdx], 10, 64)
-				if err != nil {
-					log.Fatal("Image with path ", path, " has invalid PHash")
+			imgInfo, err := processEntry(elem, cols, hashImage)
+			if err != nil {
+				errCh <- err
+				// unblock the reader of the CSV file
+				for range ch {
 				}
-				hash = phash.PHash(n)
+				return
 			}
-
-			imgCh <- NewImageInfo(hash, path)
+			imgCh <- imgInfo
 		}
 	}()
 
-	return imgCh
+	return imgCh, errCh
 }
 
-func parseColumns(csvFile *os.File, sep rune, withPhashCol bool) (<-chan *ImageInfo, error) {
+func parseColumns(csvFile *os.File, sep rune, withPhashCol bool, hashImage imageHasher) (<-chan *ImageInfo, <-chan error, error) {
 	headch, ch := processCSV(csvFile, sep)
 	headers := <-headch
 
-	// find the column containing the paths and phashes
-	pathIdx := 0
-	phashIdx := -1
+	// find the columns containing the paths, phashes and algorithms
+	cols := columns{path: 0, phash: -1, algorithm: -1, regions: -1}
 	if withPhashCol {
-		phashIdx = 0
+		cols.phash = 0
 	}
 
 	foundPathColumn := false
 	foundPhashColumn := !withPhashCol
 
 	for i, col := range headers {
+		if col == algorithmCol && cols.algorithm < 0 {
+			cols.algorithm = i
+		}
+		if col == regionsCol && cols.regions < 0 {
+			cols.regions = i
+		}
 		if !foundPathColumn && col == pathCol {
-			pathIdx = i
+			cols.path = i
 			foundPathColumn = true
 		}
 		if !foundPhashColumn && col == phashCol {
-			phashIdx = i
+			cols.phash = i
 			foundPhashColumn = true
 		}
-		if foundPathColumn && foundPhashColumn {
-			break
-		}
 	}
 
 	if !foundPathColumn {
-		return nil, errors.New("Did not find the path column")
+		return nil, nil, errors.New("Did not find the path column")
 	}
 
 	if !foundPhashColumn {
-		return nil, errors.New("Did not find the phash column")
+		return nil, nil, errors.New("Did not find the phash column")
 	}
 
-	return processEntries(ch, pathIdx, phashIdx), nil
+	imgCh, errCh := processEntries(ch, cols, hashImage)
+	return imgCh, errCh, nil
 }
 
-func load(csvPath string, sep rune, withPhashCol bool) (*vptree.VPTree, error) {
+func loadPoints(csvPath string, sep rune, withPhashCol bool, hashImage imageHasher) ([]*ImageInfo, error) {
 	csvFile, err := os.Open(csvPath)
 	defer csvFile.Close()
 	if err != nil {
 		return nil, err
 	}
 
-	ch, err := parseColumns(csvFile, sep, withPhashCol)
+	ch, errCh, err := parseColumns(csvFile, sep, withPhashCol, hashImage)
 	if err != nil {
 		return nil, err
 	}
 
-	points := make([]interface{}, 0)
+	points := make([]*ImageInfo, 0)
 
 	for elem := range ch {
 		points = append(points, elem)
 	}
 
-	tree := vptree.BuildTree(points, distanceFnc)
+	if err := <-errCh; err != nil {
+		return nil, err
+	}
+	if _, err := Algorithm(points); err != nil {
+		return nil, err
+	}
+	return points, nil
+}
+
+// Algorithm returns the algorithm which computed the hashes of the images,
+// phash.Perception when there is none, to be given to api.EngineAPI. It fails
+// when the images were hashed by several algorithms, since their hashes
+// cannot be compared.
+func Algorithm(points []*ImageInfo) (phash.Algorithm, error) {
+	if len(points) == 0 {
+		return phash.Perception, nil
+	}
+	for _, point := range points {
+		if point.algorithm != points[0].algorithm {
+			return 0, fmt.Errorf("Images hashed by both %s and %s, found at %s and %s",
+				points[0].algorithm, point.algorithm, points[0].path, point.path)
+		}
+	}
+	return points[0].algorithm, nil
+}
+
+// loadPHashPoints loads the images of the indexes supporting
+// only the PHashes, and not the extended hashes
+func loadPHashPoints(csvPath string, sep rune) ([]*ImageInfo, error) {
+	points, err := loadPoints(csvPath, sep, true, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, point := range points {
+		if point.IsExtended() {
+			return nil, errors.New("Extended hashes are only supported by the VP-Tree")
+		}
+	}
+	return points, nil
+}
+
+func load(csvPath string, sep rune, withPhashCol bool, hashImage imageHasher) (*vptree.VPTree[*ImageInfo], error) {
+	points, err := loadPoints(csvPath, sep, withPhashCol, hashImage)
+	if err != nil {
+		return nil, err
+	}
+
+	tree := vptree.BuildTree(points, HashDistance)
+	tree.SetTieBreaker(tieBreakFnc)
 	return tree, nil
 }
 
 // LoadFromCSV loads the given CSV file containing the paths
 // of the images, computes the PHashes of the images, and returns the VP-Tree
 // containing the PHash and path of each image
-func LoadFromCSV(csvPath string, sep rune) (*vptree.VPTree, error) {
-	return load(csvPath, sep, false)
+// The loading fails on the first image which cannot be read, decoded or
+// hashed, with an error naming its path
+func LoadFromCSV(csvPath string, sep rune) (*vptree.VPTree[*ImageInfo], error) {
+	return load(csvPath, sep, false, withHasher(phash.PerceptionHasher{}))
+}
+
+// LoadFromCSVWithHasher is LoadFromCSV computing the PHashes with `hasher`
+func LoadFromCSVWithHasher(csvPath string, sep rune, hasher phash.Hasher) (*vptree.VPTree[*ImageInfo], error) {
+	return load(csvPath, sep, false, withHasher(hasher))
+}
+
+// LoadExtFromCSV is LoadFromCSV computing the extended hashes of `nbits`
+// bits of the images, see phash.GetExtPHash
+func LoadExtFromCSV(csvPath string, sep rune, nbits int) (*vptree.VPTree[*ImageInfo], error) {
+	return load(csvPath, sep, false, withExtBits(nbits))
 }
 
 // LoadFromCSVPHash loads the given CSV file containg the paths
 // of the images and the corresponding PHashes, and returns the
 // VP-Tree containing the PHash and path of each image
-// Must contain the headers "phash" and "path"
-func LoadFromCSVPHash(csvPath string, sep rune) (*vptree.VPTree, error) {
-	return load(csvPath, sep, true)
+// Must contain the headers "phash" and "path", the optional "algorithm"
+// column names the algorithm of each PHash, see phash.ParseAlgorithm.
+// The extended hashes are written in hexadecimal, see phash.ParseExt.
+func LoadFromCSVPHash(csvPath string, sep rune) (*vptree.VPTree[*ImageInfo], error) {
+	return load(csvPath, sep, true, nil)
+}
+
+// LoadBKTreeFromCSVPHash loads the same CSV file as LoadFromCSVPHash,
+// and returns the BK-Tree containing the PHash and path of each image
+func LoadBKTreeFromCSVPHash(csvPath string, sep rune) (*bktree.BKTree[*ImageInfo], error) {
+	points, err := loadPHashPoints(csvPath, sep)
+	if err != nil {
+		return nil, err
+	}
+
+	tree := bktree.BuildTree(points, hashFnc)
+	tree.SetTieBreaker(tieBreakFnc)
+	return tree, nil
+}
+
+// LoadMIHFromCSVPHash loads the same CSV file as LoadFromCSVPHash, and returns
+// the multi-index hashing index splitting the PHashes into `substrings` substrings
+func LoadMIHFromCSVPHash(csvPath string, sep rune, substrings int) (*mih.MIH[*ImageInfo], error) {
+	points, err := loadPHashPoints(csvPath, sep)
+	if err != nil {
+		return nil, err
+	}
+
+	index, err := mih.BuildIndex(points, hashFnc, substrings)
+	if err != nil {
+		return nil, err
+	}
+	index.SetTieBreaker(tieBreakFnc)
+	return index, nil
+}
+
+// LoadLinearFromCSVPHash loads the same CSV file as LoadFromCSVPHash, and
+// returns the linear index scanning the packed PHashes of all the images,
+// see index.NewPackedLinear
+func LoadLinearFromCSVPHash(csvPath string, sep rune) (*index.Linear[*ImageInfo], error) {
+	points, err := loadPHashPoints(csvPath, sep)
+	if err != nil {
+		return nil, err
+	}
+
+	idx := index.NewPackedLinear(points, hashFnc, 0)
+	idx.SetTieBreaker(tieBreakFnc)
+	return idx, nil
+}
+
+// LoadCropResistantFromCSV loads the same CSV file as LoadFromCSV, computes
+// the crop resistant hashes of the images, see phash.CropResistantHashes,
+// and returns the linear index comparing the queries to the images with
+// RegionDistance
+func LoadCropResistantFromCSV(csvPath string, sep rune) (*index.Linear[*ImageInfo], error) {
+	points, err := loadPoints(csvPath, sep, false, withRegions(phash.PerceptionHasher{}))
+	if err != nil {
+		return nil, err
+	}
+	return regionIndex(points), nil
+}
+
+// LoadCropResistantFromCSVPHash loads the same CSV file as LoadFromCSVPHash,
+// whose "regions" column holds the PHashes of the regions of the images,
+// and returns the linear index comparing the queries to the images with
+// RegionDistance
+func LoadCropResistantFromCSVPHash(csvPath string, sep rune) (*index.Linear[*ImageInfo], error) {
+	points, err := loadPHashPoints(csvPath, sep)
+	if err != nil {
+		return nil, err
+	}
+	return regionIndex(points), nil
+}
+
+func regionIndex(points []*ImageInfo) *index.Linear[*ImageInfo] {
+	idx := index.NewLinear(points, RegionDistance)
+	idx.SetTieBreaker(tieBreakFnc)
+	return idx
 }
@@ -0,0 +1,84 @@
+package engine
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	phash "github.com/jx3yang/imgsearchengine/src/phash"
+	vptree "github.com/jx3yang/imgsearchengine/src/vptree"
+)
+
+// writeCSV writes the tab separated `rows` in a temporary file
+func writeCSV(t *testing.T, rows ...string) string {
+	csvPath := filepath.Join(t.TempDir(), "phash.csv")
+	if err := os.WriteFile(csvPath, []byte(strings.Join(rows, "\n")+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return csvPath
+}
+
+func TestDistanceAlgorithms(t *testing.T) {
+	// arrange
+	perception := NewImageInfoWithAlgorithm(0b0101, phash.Perception, "a.png")
+	average := NewImageInfoWithAlgorithm(0b0101, phash.Average, "b.png")
+	regions := NewRegionImageInfo([]phash.PHash{0b0101, 0b1}, phash.Average, "c.png")
+
+	// act
+	got := HashDistance(perception, average)
+	gotRegions := RegionDistance(perception, regions)
+
+	// assert
+	if got != 1 || gotRegions != 1 {
+		t.Errorf("distances across algorithms = %v and %v, want 1", got, gotRegions)
+	}
+	if HashDistance(average, average) != 0 || RegionDistance(regions, regions) != 0 {
+		t.Errorf("distances within an algorithm are not 0")
+	}
+}
+
+func TestLoadMixedAlgorithms(t *testing.T) {
+	// arrange
+	mixed := writeCSV(t, "path\tphash\talgorithm", "a.png\t5\taverage", "b.png\t7\tperception")
+	single := writeCSV(t, "path\tphash\talgorithm", "a.png\t5\taverage", "b.png\t7\taverage")
+
+	// act
+	_, errMixed := LoadFromCSVPHash(mixed, '\t')
+	_, errLinear := LoadLinearFromCSVPHash(mixed, '\t')
+	tree, err := LoadFromCSVPHash(single, '\t')
+
+	// assert
+	if errMixed == nil || errLinear == nil || !strings.Contains(errMixed.Error(), "b.png") {
+		t.Errorf("loading mixed algorithms = %v and %v, want an error naming b.png", errMixed, errLinear)
+	}
+	if err != nil || tree.Len() != 2 {
+		t.Errorf("LoadFromCSVPHash() = %v, want the 2 images", err)
+	}
+	if algorithm, err := Algorithm(tree.Points()); err != nil || algorithm != phash.Average {
+		t.Errorf("Algorithm() = %v, %v, want %v", algorithm, err, phash.Average)
+	}
+}
+
+func TestSaveExtendedRoundTrip(t *testing.T) {
+	// arrange
+	csvPath := filepath.Join(t.TempDir(), "phash.csv")
+	points := []*ImageInfo{
+		NewExtImageInfo(phash.ExtPHash{1 << 63}, "a.png"),
+		NewExtImageInfo(phash.ExtPHash{5}, "b.png"),
+	}
+	SaveTreeInfo(vptree.BuildTree(points, HashDistance), csvPath, '\t')
+
+	// act
+	tree, err := LoadFromCSVPHash(csvPath, '\t')
+
+	// assert
+	if err != nil || tree.Len() != len(points) {
+		t.Fatalf("LoadFromCSVPHash() = %v, want the %d images", err, len(points))
+	}
+	for _, point := range tree.Points() {
+		if !point.IsExtended() || len(point.GetExtPHash()) != 1 {
+			t.Errorf("%s was loaded with the PHash %d, want an extended hash of 64 bits", point.GetPath(), point.GetPHash())
+		}
+	}
+}
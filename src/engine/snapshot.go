@@ -0,0 +1,217 @@
+package engine
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"os"
+
+	phash "github.com/jx3yang/imgsearchengine/src/phash"
+	vptree "github.com/jx3yang/imgsearchengine/src/vptree"
+)
+
+// hashMarker starts the payload of the images with a PHash, it is
+// followed by the algorithm then by the path
+const hashMarker = 0
+
+// extendedMarker starts the payload of the images with an extended hash,
+// it is followed by the algorithm, the number of words of the hash as a
+// uvarint, the words, then by the path
+const extendedMarker = 1
+
+// regionsMarker starts the payload of the images with the hashes of their
+// regions, which are encoded as the words of the extended hashes
+const regionsMarker = 2
+
+// extendedRegionsMarker starts the payload of the images with both an
+// extended hash and the hashes of their regions, which are encoded as the
+// words of the extended hash followed by the words of the regions
+const extendedRegionsMarker = 3
+
+// appendWords appends the number of `words` as a uvarint, then the words
+func appendWords(payload []byte, words []uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	payload = append(payload, buf[:binary.PutUvarint(buf[:], uint64(len(words)))]...)
+	for _, word := range words {
+		binary.LittleEndian.PutUint64(buf[:], word)
+		payload = append(payload, buf[:8]...)
+	}
+	return payload
+}
+
+// decodeWords returns the words encoded at the start of `data` by
+// appendWords, and the rest of `data`
+func decodeWords(data []byte) ([]uint64, []byte, error) {
+	count, n := binary.Uvarint(data)
+	if n <= 0 || count == 0 || count > uint64(len(data)-n)/8 {
+		return nil, nil, errors.New("Invalid hashes in snapshot")
+	}
+	data = data[n:]
+	words := make([]uint64, count)
+	for i := range words {
+		words[i] = binary.LittleEndian.Uint64(data[8*i:])
+	}
+	return words, data[8*count:], nil
+}
+
+// decodeRegions is decodeWords returning the hashes of the regions
+func decodeRegions(data []byte) ([]phash.PHash, []byte, error) {
+	words, rest, err := decodeWords(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	regions := make([]phash.PHash, len(words))
+	for i, word := range words {
+		regions[i] = phash.PHash(word)
+	}
+	return regions, rest, nil
+}
+
+func encodePayload(imgInfo *ImageInfo) []byte {
+	regions := make([]uint64, len(imgInfo.regions))
+	for i, region := range imgInfo.regions {
+		regions[i] = uint64(region)
+	}
+	payload := []byte{hashMarker, byte(imgInfo.algorithm)}
+	switch {
+	case imgInfo.IsExtended() && len(regions) > 0:
+		payload[0] = extendedRegionsMarker
+		payload = appendWords(payload, imgInfo.ext)
+		payload = appendWords(payload, regions)
+	case imgInfo.IsExtended():
+		payload[0] = extendedMarker
+		payload = appendWords(payload, imgInfo.ext)
+	case len(regions) > 0:
+		payload[0] = regionsMarker
+		payload = appendWords(payload, regions)
+	}
+	return append(payload, imgInfo.path...)
+}
+
+func decodePayload(hash phash.PHash, payload []byte) (*ImageInfo, error) {
+	if len(payload) < 2 {
+		return nil, errors.New("Invalid image info in snapshot")
+	}
+	imgInfo := &ImageInfo{hash: hash, algorithm: phash.Algorithm(payload[1])}
+	data := payload[2:]
+	var err error
+	switch payload[0] {
+	case hashMarker:
+	case extendedMarker:
+		imgInfo.ext, data, err = decodeWords(data)
+	case regionsMarker:
+		imgInfo.regions, data, err = decodeRegions(data)
+	case extendedRegionsMarker:
+		if imgInfo.ext, data, err = decodeWords(data); err == nil {
+			imgInfo.regions, data, err = decodeRegions(data)
+		}
+	default:
+		return nil, errors.New("Unknown image info in snapshot")
+	}
+	if err != nil {
+		return nil, err
+	}
+	imgInfo.path = string(data)
+	return imgInfo, nil
+}
+
+func encodeImageInfo(imgInfo *ImageInfo) ([]byte, error) {
+	payload := encodePayload(imgInfo)
+	data := make([]byte, 8+len(payload))
+	binary.LittleEndian.PutUint64(data, uint64(imgInfo.hash))
+	copy(data[8:], payload)
+	return data, nil
+}
+
+func decodeImageInfo(data []byte) (*ImageInfo, error) {
+	if len(data) < 8 {
+		return nil, errors.New("Invalid image info in snapshot")
+	}
+	hash := phash.PHash(binary.LittleEndian.Uint64(data))
+	return decodePayload(hash, data[8:])
+}
+
+// SaveSnapshot will save the structure of a VP-Tree holding
+// *ImageInfo structs as nodes in a binary file given its path
+func SaveSnapshot(tree *vptree.VPTree[*ImageInfo], snapshotPath string) error {
+	file, err := os.Create(snapshotPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	if err := tree.WriteSnapshot(writer, encodeImageInfo); err != nil {
+		return err
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+// LoadSnapshot restores the VP-Tree saved by SaveSnapshot
+// without recomputing the distances between the images
+func LoadSnapshot(snapshotPath string) (*vptree.VPTree[*ImageInfo], error) {
+	file, err := os.Open(snapshotPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	tree, err := vptree.ReadSnapshot(file, HashDistance, decodeImageInfo)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := Algorithm(tree.Points()); err != nil {
+		return nil, err
+	}
+	tree.SetTieBreaker(tieBreakFnc)
+	return tree, nil
+}
+
+func flatDistanceFnc(hash1, hash2 uint64) float64 {
+	return phash.NormHammingDist(phash.PHash(hash1), phash.PHash(hash2))
+}
+
+// SaveFlatSnapshot will save the VP-Tree holding *ImageInfo structs
+// as nodes in a flat binary file which can be memory-mapped. The flat
+// snapshots only support the PHashes, and not the extended hashes.
+func SaveFlatSnapshot(tree *vptree.VPTree[*ImageInfo], snapshotPath string) error {
+	for _, imgInfo := range tree.Points() {
+		if imgInfo.IsExtended() {
+			return errors.New("Flat snapshots do not support extended hashes")
+		}
+	}
+
+	file, err := os.Create(snapshotPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	key := func(imgInfo *ImageInfo) uint64 { return uint64(imgInfo.hash) }
+	flat := vptree.NewFlatTree(tree, key, encodePayload, flatDistanceFnc)
+
+	writer := bufio.NewWriter(file)
+	if err := flat.WriteFlat(writer); err != nil {
+		return err
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+// OpenFlatSnapshot memory-maps the flat VP-Tree saved by SaveFlatSnapshot
+// once its checksum is verified, see vptree.OpenFlat, use FlatImageInfo for
+// retrieving the images found by its searches
+func OpenFlatSnapshot(snapshotPath string) (*vptree.FlatTree, error) {
+	return vptree.OpenFlat(snapshotPath, flatDistanceFnc)
+}
+
+// FlatImageInfo returns the image stored in the node `idx` of a flat VP-Tree
+// saved by SaveFlatSnapshot
+func FlatImageInfo(tree *vptree.FlatTree, idx int) (*ImageInfo, error) {
+	return decodePayload(phash.PHash(tree.Hash(idx)), tree.Payload(idx))
+}
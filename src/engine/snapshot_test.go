@@ -0,0 +1,124 @@
+package engine
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	phash "github.com/jx3yang/imgsearchengine/src/phash"
+	vptree "github.com/jx3yang/imgsearchengine/src/vptree"
+)
+
+// manyRegions returns an image with more regions than a byte can count
+func manyRegions() *ImageInfo {
+	hashes := make([]phash.PHash, 300)
+	for i := range hashes {
+		hashes[i] = phash.PHash(uint64(i) * 0x9e3779b97f4a7c15)
+	}
+	return NewRegionImageInfo(hashes, phash.Average, "regions.png")
+}
+
+func testImageInfos() map[string]*ImageInfo {
+	return map[string]*ImageInfo{
+		"perception": NewImageInfo(0x8000000000000001, "a.png"),
+		"algorithm":  NewImageInfoWithAlgorithm(0b0101, phash.Wavelet, "b.png"),
+		"extended":   NewExtImageInfo(phash.ExtPHash{1, 2, 3, 1 << 63}, "c.png"),
+		"regions":    NewRegionImageInfo([]phash.PHash{7, 8, 9}, phash.BlockMean, "d.png"),
+		"many":       manyRegions(),
+		"extended regions": {
+			ext:       phash.ExtPHash{4, 5, 6, 7},
+			regions:   []phash.PHash{8, 9},
+			algorithm: phash.Perception,
+			path:      "e.png",
+		},
+		"empty path": NewImageInfoWithAlgorithm(3, phash.Difference, ""),
+	}
+}
+
+func TestImageInfoRoundTrip(t *testing.T) {
+	for name, want := range testImageInfos() {
+		// act
+		data, err := encodeImageInfo(want)
+		if err != nil {
+			t.Fatalf("%s: encodeImageInfo() = %v", name, err)
+		}
+		got, err := decodeImageInfo(data)
+
+		// assert
+		if err != nil || !reflect.DeepEqual(want, got) {
+			t.Errorf("%s: decodeImageInfo() = %+v, %v, want %+v", name, got, err, want)
+		}
+	}
+}
+
+func TestDecodeInvalidPayload(t *testing.T) {
+	// arrange
+	extended, _ := encodeImageInfo(NewExtImageInfo(phash.ExtPHash{1, 2, 3, 4}, "c.png"))
+	payloads := map[string][]byte{
+		"truncated words": extended[:8+20],
+		"no marker":       extended[:8+1],
+		"unknown marker":  append(make([]byte, 8), "a.png"...),
+	}
+
+	for name, data := range payloads {
+		// act
+		_, err := decodeImageInfo(data)
+
+		// assert
+		if err == nil {
+			t.Errorf("%s: decodeImageInfo() succeeded", name)
+		}
+	}
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	// arrange
+	snapshotPath := filepath.Join(t.TempDir(), "index.snapshot")
+	points := []*ImageInfo{
+		NewExtImageInfo(phash.ExtPHash{1, 2, 3, 4}, "a.png"),
+		NewExtImageInfo(phash.ExtPHash{1, 2, 3, 5}, "b.png"),
+		NewExtImageInfo(phash.ExtPHash{^uint64(0), 0, 7, 0}, "c.png"),
+	}
+	tree := vptree.BuildTree(points, HashDistance)
+
+	// act
+	if err := SaveSnapshot(tree, snapshotPath); err != nil {
+		t.Fatalf("SaveSnapshot() = %v", err)
+	}
+	got, err := LoadSnapshot(snapshotPath)
+
+	// assert
+	if err != nil || got.Len() != len(points) {
+		t.Fatalf("LoadSnapshot() = %v, want %d images", err, len(points))
+	}
+	results, _ := got.KNNSearch(points[2], 1)
+	if len(results) != 1 || !reflect.DeepEqual(points[2], results[0].Point) {
+		t.Errorf("KNNSearch() = %v, want %v", results, points[2])
+	}
+}
+
+func TestFlatSnapshotRoundTrip(t *testing.T) {
+	// arrange
+	snapshotPath := filepath.Join(t.TempDir(), "index.flat")
+	points := []*ImageInfo{
+		NewImageInfoWithAlgorithm(0b0101, phash.Average, "a.png"),
+		NewImageInfoWithAlgorithm(0b1111, phash.Average, "b.png"),
+	}
+	if err := SaveFlatSnapshot(vptree.BuildTree(points, HashDistance), snapshotPath); err != nil {
+		t.Fatalf("SaveFlatSnapshot() = %v", err)
+	}
+
+	// act
+	flat, err := OpenFlatSnapshot(snapshotPath)
+	if err != nil {
+		t.Fatalf("OpenFlatSnapshot() = %v", err)
+	}
+	defer flat.Close()
+	results, _ := flat.KNNSearch(0b0101, 1)
+	got, err := FlatImageInfo(flat, results[0].Point)
+
+	// assert
+	if err != nil || !reflect.DeepEqual(points[0], got) {
+		t.Errorf("FlatImageInfo() = %+v, %v, want %+v", got, err, points[0])
+	}
+}
@@ -1,14 +1,21 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"io"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/jx3yang/imgsearchengine/src/api"
 	"github.com/jx3yang/imgsearchengine/src/engine"
+	"github.com/jx3yang/imgsearchengine/src/phash"
+	"github.com/jx3yang/imgsearchengine/src/vptree"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
@@ -17,12 +24,20 @@ import (
 // internal paths
 const imagePath = "images/"
 const tempImagesPath = imagePath + "temp/"
+const snapshotPath = "index.snapshot"
+const csvPath = "load_file_phash.csv"
 
 // external paths
 const port = "8080"
 const devAddress = "http://localhost:" + port
 const pathPrefix = "/images/"
 
+// maximum number of images returned by a range search
+const maxResults = 500
+
+// time left to the requests in flight when the server stops
+const shutdownTimeout = 10 * time.Second
+
 func ping(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(map[string]bool{"ready": true})
 }
@@ -54,15 +69,67 @@ func imageUpload(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(map[string]string{"path": devAddress + "/" + internalPath})
 }
 
-func main() {
+// snapshotFresh reports whether the snapshot exists and was saved after the
+// last change of the CSV file, without which the snapshot is kept as well
+func snapshotFresh() bool {
+	snapshot, err := os.Stat(snapshotPath)
+	if err != nil {
+		return false
+	}
+	csv, err := os.Stat(csvPath)
+	return err != nil || !csv.ModTime().After(snapshot.ModTime())
+}
+
+// loadTree restores the VP-Tree from the snapshot, or rebuilds it from
+// the CSV file when the snapshot is missing or older than the CSV file
+func loadTree() (*vptree.VPTree[*engine.ImageInfo], error) {
+	if snapshotFresh() {
+		tree, err := engine.LoadSnapshot(snapshotPath)
+		if err == nil {
+			return tree, nil
+		}
+		log.Println("Unable to load the snapshot: ", err)
+	}
+
 	// tree, err := engine.LoadFromCSV("load_file.csv", '\t')
-	tree, err := engine.LoadFromCSVPHash("load_file_phash.csv", '\t')
+	tree, err := engine.LoadFromCSVPHash(csvPath, '\t')
+	if err != nil {
+		return nil, err
+	}
+	if errS := engine.SaveSnapshot(tree, snapshotPath); errS != nil {
+		log.Println("Unable to save the snapshot: ", errS)
+	}
+	return tree, nil
+}
 
+func main() {
+	tree, err := loadTree()
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	engineService := api.EngineAPI{Tree: tree}
+	stats := tree.Stats()
+	log.Printf("Loaded %d images, depth %d (average %.1f), balance %.2f",
+		stats.Nodes-stats.Deleted, stats.MaxDepth, stats.AvgDepth, stats.Balance)
+
+	// the queries are hashed by the algorithm of the indexed hashes
+	algorithm, err := engine.Algorithm(tree.Points())
+	if err != nil {
+		log.Fatal(err)
+	}
+	hasher, err := phash.NewHasher(algorithm)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	engineService := api.EngineAPI{
+		Index:     tree,
+		Hasher:    hasher,
+		Algorithm: algorithm,
+		Budget:    vptree.SearchOptions{MaxResults: maxResults},
+		// the uploaded images are served by this server
+		Fetcher: &api.Fetcher{AllowedHosts: []string{"localhost"}, AllowPrivate: true},
+	}
 
 	router := mux.NewRouter().StrictSlash(true)
 
@@ -70,9 +137,30 @@ func main() {
 	router.HandleFunc("/knn", engineService.KNNSearch).
 		Methods("POST")
 
+	router.HandleFunc("/search", engineService.Search).
+		Methods("POST")
+
+	router.HandleFunc("/knn/batch", engineService.KNNBatchSearch).
+		Methods("POST")
+
 	router.HandleFunc("/rangesearch", engineService.RangeSearch).
 		Methods("POST")
 
+	// the snapshot is saved on shutdown once the tree was modified
+	var modified int32
+	write := func(handler http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			atomic.StoreInt32(&modified, 1)
+			handler(w, r)
+		}
+	}
+
+	router.HandleFunc("/insert", write(engineService.Insert)).
+		Methods("POST")
+
+	router.HandleFunc("/delete", write(engineService.Delete)).
+		Methods("POST")
+
 	router.HandleFunc("/ping-engine", engineService.Ping).
 		Methods("GET")
 
@@ -86,5 +174,25 @@ func main() {
 	router.HandleFunc("/image-upload", imageUpload).
 		Methods("POST")
 
-	log.Fatal(http.ListenAndServe(":"+port, router))
+	server := &http.Server{Addr: ":" + port, Handler: router}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+	<-ctx.Done()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Println("Unable to stop the server: ", err)
+	}
+	if atomic.LoadInt32(&modified) != 0 {
+		if err := engine.SaveSnapshot(tree, snapshotPath); err != nil {
+			log.Println("Unable to save the snapshot: ", err)
+		}
+	}
 }
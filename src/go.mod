@@ -1,11 +1,12 @@
 module github.com/jx3yang/imgsearchengine/src
 
-go 1.15
+go 1.19
 
 require (
 	github.com/corona10/goimagehash v1.0.2
 	github.com/ef-ds/deque v1.0.4
-	github.com/go-delve/delve v1.5.0 // indirect
 	github.com/google/uuid v1.1.1
 	github.com/gorilla/mux v1.8.0
 )
+
+require github.com/nfnt/resize v0.0.0-20160724205520-891127d8d1b5 // indirect
@@ -0,0 +1,72 @@
+package index
+
+import (
+	"context"
+	"runtime"
+	"sync"
+)
+
+// BatchResult holds the outcome of one query of a batch
+type BatchResult[T any] struct {
+	Results []Result[T]
+	// Partial is set when the search stopped before completion
+	Partial bool
+	Err     error
+}
+
+// BatchSearch runs `search` on each of the `queries` using a pool of at most
+// `workers` goroutines, or GOMAXPROCS if `workers` is not positive. The ith
+// result answers the ith query. The queries not started yet when `ctx` is
+// done are not run, and fail with the error of `ctx`.
+func BatchSearch[Q, T any](ctx context.Context, queries []Q, workers int, search func(query Q) ([]Result[T], bool, error)) []BatchResult[T] {
+	if workers < 1 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if workers > len(queries) {
+		workers = len(queries)
+	}
+
+	results := make([]BatchResult[T], len(queries))
+	next := make(chan int)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				if err := ctx.Err(); err != nil {
+					results[i].Err = err
+					continue
+				}
+				results[i].Results, results[i].Partial, results[i].Err = search(queries[i])
+			}
+		}()
+	}
+
+	for i := range queries {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	return results
+}
+
+// BatchKNNSearch runs the KNN search of each of the `points` on `idx`
+// concurrently, see BatchSearch
+func BatchKNNSearch[T any](ctx context.Context, idx SearchIndex[T], points []T, k uint, workers int) []BatchResult[T] {
+	return BatchSearch(ctx, points, workers, func(point T) ([]Result[T], bool, error) {
+		results, err := idx.KNNSearch(point, k)
+		return results, false, err
+	})
+}
+
+// BatchRangeSearch runs the range search of each of the `points` on `idx`
+// concurrently, see BatchSearch
+func BatchRangeSearch[T any](ctx context.Context, idx SearchIndex[T], points []T, threshold float64, workers int) []BatchResult[T] {
+	return BatchSearch(ctx, points, workers, func(point T) ([]Result[T], bool, error) {
+		results, err := idx.RangeSearch(point, threshold)
+		return results, false, err
+	})
+}
@@ -0,0 +1,53 @@
+package index
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestBatchKNNSearch(t *testing.T) {
+	// arrange
+	linear := NewLinear([]float64{4, 1, 3.5, 2, 6}, distanceFnc)
+	linear.SetTieBreaker(func(a, b float64) bool { return a < b })
+	points := []float64{0, 3, 6, 10, 2.5}
+
+	for _, workers := range []int{0, 1, 2, 8} {
+		// act
+		got := BatchKNNSearch[float64](context.Background(), linear, points, 2, workers)
+
+		// assert
+		if len(got) != len(points) {
+			t.Fatalf("BatchKNNSearch() returned %d results, want %d", len(got), len(points))
+		}
+		for i, point := range points {
+			want, _ := linear.KNNSearch(point, 2)
+			if got[i].Err != nil || !reflect.DeepEqual(want, got[i].Results) {
+				t.Errorf("BatchKNNSearch()[%d] = %v, %v, want %v", i, got[i].Results, got[i].Err, want)
+			}
+		}
+	}
+}
+
+func TestBatchSearchCancelled(t *testing.T) {
+	// arrange
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	calls := 0
+
+	// act
+	got := BatchSearch(ctx, []int{1, 2, 3}, 1, func(query int) ([]Result[int], bool, error) {
+		calls++
+		return nil, false, nil
+	})
+
+	// assert
+	if calls != 0 {
+		t.Errorf("search called %d times after the cancellation", calls)
+	}
+	for i := range got {
+		if got[i].Err != context.Canceled {
+			t.Errorf("BatchSearch()[%d].Err = %v, want %v", i, got[i].Err, context.Canceled)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+// Package index defines the interface shared by the structures
+// indexing the images for KNN and range searches
+package index
+
+import "sort"
+
+// Result is a point found by a search along with its distance to the query
+type Result[T any] struct {
+	Point    T
+	Distance float64
+}
+
+// SearchIndex is implemented by the structures indexing points for
+// KNN and range searches
+type SearchIndex[T any] interface {
+	// KNNSearch returns the k nearest neighbours of `point`,
+	// sorted by ascending distance
+	KNNSearch(point T, k uint) ([]Result[T], error)
+	// RangeSearch returns the points within a `threshold` distance
+	// from `point`, sorted by ascending distance
+	RangeSearch(point T, threshold float64) ([]Result[T], error)
+	// Insert adds `point` to the index
+	Insert(point T)
+	// Delete removes `point` from the index and reports whether it was found
+	Delete(point T) bool
+	// Len returns the number of points in the index
+	Len() int
+	// Ready reports whether the index holds points and can answer queries
+	Ready() bool
+}
+
+// SortResults sorts the results by ascending distance, then using
+// `tieBreak` if it is not nil
+func SortResults[T any](results []Result[T], tieBreak func(point1, point2 T) bool) {
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Distance != results[j].Distance || tieBreak == nil {
+			return results[i].Distance < results[j].Distance
+		}
+		return tieBreak(results[i].Point, results[j].Point)
+	})
+}
@@ -0,0 +1,160 @@
+package index
+
+import (
+	"errors"
+	"runtime"
+	"sync"
+
+	phash "github.com/jx3yang/imgsearchengine/src/phash"
+)
+
+var _ SearchIndex[int] = (*Linear[int])(nil)
+
+// Linear is the brute-force index comparing the query to every point.
+// It is exact by construction, hence it serves as the reference for
+// the other indexes, and it is hard to beat on small collections.
+type Linear[T comparable] struct {
+	points      []T
+	distanceFnc func(T, T) float64
+	// hashes holds the PHashes of the points, in the same order,
+	// when they are packed for the scan, see NewPackedLinear
+	hashes   []uint64
+	key      func(T) phash.PHash
+	workers  int
+	tieBreak func(point1, point2 T) bool
+	mu       sync.RWMutex
+}
+
+// NewLinear returns the linear index holding `points`
+func NewLinear[T comparable](points []T, distanceFnc func(T, T) float64) *Linear[T] {
+	return &Linear[T]{
+		points:      append([]T(nil), points...),
+		distanceFnc: distanceFnc,
+	}
+}
+
+// NewPackedLinear returns the linear index holding `points`, compared by
+// the normalized Hamming distance between their PHashes, where `key`
+// returns the PHash of a point. The PHashes are packed in a single array
+// scanned by XOR and popcount, split across at most `workers` goroutines,
+// or GOMAXPROCS if `workers` is not positive. The scan of contiguous
+// memory beats the pointer chasing of the trees on collections of up to
+// about 100k images.
+func NewPackedLinear[T comparable](points []T, key func(T) phash.PHash, workers int) *Linear[T] {
+	if workers < 1 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	linear := &Linear[T]{
+		points: append([]T(nil), points...),
+		distanceFnc: func(point1, point2 T) float64 {
+			return phash.NormHammingDist(key(point1), key(point2))
+		},
+		hashes:  make([]uint64, len(points)),
+		key:     key,
+		workers: workers,
+	}
+	for i, point := range points {
+		linear.hashes[i] = uint64(key(point))
+	}
+	return linear
+}
+
+// packed reports whether the PHashes of the points are packed
+func (linear *Linear[T]) packed() bool { return linear.key != nil }
+
+// SetTieBreaker sets the function ordering the points at the same distance
+// from the query, which otherwise keep their order in the index
+func (linear *Linear[T]) SetTieBreaker(less func(point1, point2 T) bool) {
+	linear.mu.Lock()
+	defer linear.mu.Unlock()
+	linear.tieBreak = less
+}
+
+func (linear *Linear[T]) scan(point T, keep func(float64) bool) []Result[T] {
+	results := make([]Result[T], 0)
+	for _, p := range linear.points {
+		if dist := linear.distanceFnc(point, p); keep(dist) {
+			results = append(results, Result[T]{Point: p, Distance: dist})
+		}
+	}
+	SortResults(results, linear.tieBreak)
+	return results
+}
+
+// KNNSearch will return the k nearest neighbours of the given `point`,
+// sorted by ascending distance
+func (linear *Linear[T]) KNNSearch(point T, k uint) ([]Result[T], error) {
+	if k < 1 {
+		return nil, errors.New("Invalid k")
+	}
+	linear.mu.RLock()
+	defer linear.mu.RUnlock()
+
+	if linear.packed() {
+		return linear.packedKNNSearch(point, k), nil
+	}
+	results := linear.scan(point, func(float64) bool { return true })
+	if uint(len(results)) > k {
+		results = results[:k]
+	}
+	return results, nil
+}
+
+// RangeSearch will return all the points within a `threshold` distance
+// from the given `point`, sorted by ascending distance
+func (linear *Linear[T]) RangeSearch(point T, threshold float64) ([]Result[T], error) {
+	if threshold < 0 {
+		return nil, errors.New("Threshold must be positive")
+	}
+	linear.mu.RLock()
+	defer linear.mu.RUnlock()
+
+	if linear.packed() {
+		return linear.collect(uint64(linear.key(point)), phash.ThresholdBits(threshold)), nil
+	}
+	return linear.scan(point, func(dist float64) bool { return dist <= threshold }), nil
+}
+
+// Insert adds `point` to the index
+func (linear *Linear[T]) Insert(point T) {
+	linear.mu.Lock()
+	defer linear.mu.Unlock()
+	linear.points = append(linear.points, point)
+	if linear.packed() {
+		linear.hashes = append(linear.hashes, uint64(linear.key(point)))
+	}
+}
+
+// Delete removes `point` from the index and reports whether it was found
+func (linear *Linear[T]) Delete(point T) bool {
+	linear.mu.Lock()
+	defer linear.mu.Unlock()
+
+	for i, p := range linear.points {
+		if p == point {
+			last := len(linear.points) - 1
+			linear.points[i] = linear.points[last]
+			var zero T
+			linear.points[last] = zero
+			linear.points = linear.points[:last]
+			if linear.packed() {
+				linear.hashes[i] = linear.hashes[last]
+				linear.hashes = linear.hashes[:last]
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// Len returns the number of points in the index
+func (linear *Linear[T]) Len() int {
+	linear.mu.RLock()
+	defer linear.mu.RUnlock()
+	return len(linear.points)
+}
+
+// Ready reports whether the index holds any point
+func (linear *Linear[T]) Ready() bool {
+	return linear.Len() > 0
+}
@@ -0,0 +1,67 @@
+package index
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func distanceFnc(a, b float64) float64 {
+	return math.Abs(a - b)
+}
+
+func TestLinearKNNSearch(t *testing.T) {
+	// arrange
+	linear := NewLinear([]float64{4, 1, 3.5, 2, 6}, distanceFnc)
+	linear.SetTieBreaker(func(a, b float64) bool { return a < b })
+
+	want := []Result[float64]{
+		{Point: 3.5, Distance: 0.5},
+		{Point: 2, Distance: 1},
+		{Point: 4, Distance: 1},
+	}
+
+	// act
+	got, _ := linear.KNNSearch(3, 3)
+
+	// assert
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("KNNSearch() = %v, want %v", got, want)
+	}
+}
+
+func TestLinearRangeSearch(t *testing.T) {
+	// arrange
+	linear := NewLinear([]float64{4, 1, 3.5, 2, 6}, distanceFnc)
+
+	want := []Result[float64]{
+		{Point: 6, Distance: 0},
+		{Point: 4, Distance: 2},
+	}
+
+	// act
+	got, _ := linear.RangeSearch(6, 2)
+
+	// assert
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("RangeSearch() = %v, want %v", got, want)
+	}
+}
+
+func TestLinearDelete(t *testing.T) {
+	// arrange
+	linear := NewLinear([]float64{4, 1}, distanceFnc)
+
+	// act
+	deleted := linear.Delete(4)
+	deletedAgain := linear.Delete(4)
+	linear.Delete(1)
+
+	// assert
+	if !deleted || deletedAgain {
+		t.Errorf("Delete() = %v then %v, want true then false", deleted, deletedAgain)
+	}
+	if linear.Ready() || linear.Len() != 0 {
+		t.Errorf("Ready() = %v and Len() = %d after deleting every point", linear.Ready(), linear.Len())
+	}
+}
@@ -0,0 +1,101 @@
+package index
+
+import (
+	"math/bits"
+	"sync"
+
+	phash "github.com/jx3yang/imgsearchengine/src/phash"
+)
+
+// shards smaller than this are not worth a goroutine
+const minShardSize = 4096
+
+// shards returns the number of goroutines scanning the packed hashes
+func (linear *Linear[T]) shards() int {
+	shards := linear.workers
+	if limit := len(linear.hashes) / minShardSize; shards > limit {
+		shards = limit
+	}
+	if shards < 1 {
+		shards = 1
+	}
+	return shards
+}
+
+// forEachShard calls `fn` concurrently on `shards` contiguous
+// shards covering all the packed hashes
+func (linear *Linear[T]) forEachShard(shards int, fn func(shard, start, end int)) {
+	n := len(linear.hashes)
+	if shards == 1 {
+		fn(0, 0, n)
+		return
+	}
+
+	var wg sync.WaitGroup
+	for shard := 0; shard < shards; shard++ {
+		wg.Add(1)
+		go func(shard int) {
+			defer wg.Done()
+			fn(shard, shard*n/shards, (shard+1)*n/shards)
+		}(shard)
+	}
+	wg.Wait()
+}
+
+// collect returns the points within `radius` bits of `hash`, sorted
+func (linear *Linear[T]) collect(hash uint64, radius int) []Result[T] {
+	shards := linear.shards()
+	found := make([][]Result[T], shards)
+
+	linear.forEachShard(shards, func(shard, start, end int) {
+		results := make([]Result[T], 0)
+		for i, h := range linear.hashes[start:end] {
+			if dist := bits.OnesCount64(h ^ hash); dist <= radius {
+				results = append(results, Result[T]{
+					Point:    linear.points[start+i],
+					Distance: float64(dist) / phash.Bits,
+				})
+			}
+		}
+		found[shard] = results
+	})
+
+	results := make([]Result[T], 0)
+	for _, shardResults := range found {
+		results = append(results, shardResults...)
+	}
+	SortResults(results, linear.tieBreak)
+	return results
+}
+
+// packedKNNSearch returns the k nearest neighbours of `point` among the
+// packed hashes. A first scan counts the hashes at each distance to find
+// the distance of the kth neighbour, a second one collects the hashes
+// within that distance.
+func (linear *Linear[T]) packedKNNSearch(point T, k uint) []Result[T] {
+	hash := uint64(linear.key(point))
+	shards := linear.shards()
+	counts := make([][phash.Bits + 1]uint, shards)
+
+	linear.forEachShard(shards, func(shard, start, end int) {
+		for _, h := range linear.hashes[start:end] {
+			counts[shard][bits.OnesCount64(h^hash)]++
+		}
+	})
+
+	radius := 0
+	for found := uint(0); radius < phash.Bits; radius++ {
+		for shard := range counts {
+			found += counts[shard][radius]
+		}
+		if found >= k {
+			break
+		}
+	}
+
+	results := linear.collect(hash, radius)
+	if uint(len(results)) > k {
+		results = results[:k]
+	}
+	return results
+}
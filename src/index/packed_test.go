@@ -0,0 +1,106 @@
+package index_test
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"strconv"
+	"testing"
+
+	index "github.com/jx3yang/imgsearchengine/src/index"
+	hashtest "github.com/jx3yang/imgsearchengine/src/internal/hashtest"
+	phash "github.com/jx3yang/imgsearchengine/src/phash"
+	vptree "github.com/jx3yang/imgsearchengine/src/vptree"
+)
+
+func TestPackedSearch(t *testing.T) {
+	// arrange
+	r := rand.New(rand.NewSource(1))
+	// enough hashes for 5 shards
+	points := hashtest.ClusteredHashes(5*4096, r)
+	reference := hashtest.Reference(points)
+
+	for _, workers := range []int{1, 3, 8} {
+		packed := index.NewPackedLinear(points, hashtest.Identity, workers)
+		packed.SetTieBreaker(hashtest.Less)
+
+		for i := 0; i < 10; i++ {
+			point := points[r.Intn(len(points))] ^ phash.PHash(r.Uint64()&r.Uint64()&r.Uint64())
+			k := uint(1 + r.Intn(50))
+
+			// act
+			gotKNN, _ := packed.KNNSearch(point, k)
+			threshold := []float64{0.15, math.Nextafter(6./64, 0), 6. / 64}[i%3]
+			gotRange, _ := packed.RangeSearch(point, threshold)
+
+			// assert
+			wantKNN, _ := reference.KNNSearch(point, k)
+			wantRange, _ := reference.RangeSearch(point, threshold)
+			if !reflect.DeepEqual(wantKNN, gotKNN) {
+				t.Errorf("KNNSearch() with %d workers = %v, want %v", workers, gotKNN, wantKNN)
+			}
+			if !reflect.DeepEqual(wantRange, gotRange) {
+				t.Errorf("RangeSearch(%v) with %d workers = %v, want %v", threshold, workers, gotRange, wantRange)
+			}
+		}
+	}
+}
+
+func TestPackedInsertDelete(t *testing.T) {
+	// arrange
+	packed := index.NewPackedLinear(nil, hashtest.Identity, 0)
+	packed.SetTieBreaker(hashtest.Less)
+	for _, point := range []phash.PHash{0b1011, 0b1001, 0b0001, 0b1111, 0b0000} {
+		packed.Insert(point)
+	}
+
+	want := []index.Result[phash.PHash]{
+		{Point: 0b1001, Distance: 1. / 64},
+		{Point: 0b1111, Distance: 1. / 64},
+	}
+
+	// act
+	deleted := packed.Delete(0b1011)
+	deletedAgain := packed.Delete(0b1011)
+	got, _ := packed.KNNSearch(0b1011, 2)
+
+	// assert
+	if !deleted || deletedAgain {
+		t.Errorf("Delete() = %v then %v, want true then false", deleted, deletedAgain)
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("KNNSearch() = %v, want %v", got, want)
+	}
+	if packed.Len() != 4 {
+		t.Errorf("Len() = %d, want 4", packed.Len())
+	}
+}
+
+// BenchmarkPackedKNNSearch compares the packed linear index to the
+// generic one and to the VP-Tree for collections of different sizes
+func BenchmarkPackedKNNSearch(b *testing.B) {
+	for _, n := range []int{10000, 100000} {
+		r := rand.New(rand.NewSource(1))
+		points := hashtest.ClusteredHashes(n, r)
+		queries := make([]phash.PHash, 200)
+		for i := range queries {
+			queries[i] = points[r.Intn(len(points))] ^ 1<<uint(r.Intn(64))
+		}
+
+		indexes := []struct {
+			name  string
+			index index.SearchIndex[phash.PHash]
+		}{
+			{"packed", index.NewPackedLinear(points, hashtest.Identity, 0)},
+			{"linear", index.NewLinear(points, phash.NormHammingDist)},
+			{"vptree", vptree.BuildTree(append([]phash.PHash(nil), points...), phash.NormHammingDist)},
+		}
+		for _, idx := range indexes {
+			b.Run(idx.name+"/"+strconv.Itoa(n), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					idx.index.KNNSearch(queries[i%len(queries)], 10)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,46 @@
+// Package hashtest holds the fixtures shared by the tests
+// of the indexes over the PHashes
+package hashtest
+
+import (
+	"math/rand"
+
+	index "github.com/jx3yang/imgsearchengine/src/index"
+	phash "github.com/jx3yang/imgsearchengine/src/phash"
+)
+
+// Identity is the key of the indexes holding the PHashes themselves
+func Identity(hash phash.PHash) phash.PHash { return hash }
+
+// Less orders the PHashes at the same distance from the query
+func Less(hash1, hash2 phash.PHash) bool { return hash1 < hash2 }
+
+// ClusteredHashes returns `n` distinct hashes grouped around random
+// centers, as the hashes of near-duplicate images are
+func ClusteredHashes(n int, r *rand.Rand) []phash.PHash {
+	centers := make([]uint64, n/100+1)
+	for i := range centers {
+		centers[i] = r.Uint64()
+	}
+	seen := make(map[phash.PHash]bool)
+	hashes := make([]phash.PHash, 0, n)
+	for len(hashes) < n {
+		hash := centers[r.Intn(len(centers))]
+		for flips := r.Intn(12); flips > 0; flips-- {
+			hash ^= 1 << uint(r.Intn(64))
+		}
+		if !seen[phash.PHash(hash)] {
+			seen[phash.PHash(hash)] = true
+			hashes = append(hashes, phash.PHash(hash))
+		}
+	}
+	return hashes
+}
+
+// Reference returns the brute-force index of `points`, whose exact
+// results the other indexes are compared to, the ties ordered by Less
+func Reference(points []phash.PHash) *index.Linear[phash.PHash] {
+	reference := index.NewLinear(points, phash.NormHammingDist)
+	reference.SetTieBreaker(Less)
+	return reference
+}
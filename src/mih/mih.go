@@ -0,0 +1,294 @@
+package mih
+
+import (
+	"errors"
+	"sync"
+
+	index "github.com/jx3yang/imgsearchengine/src/index"
+	phash "github.com/jx3yang/imgsearchengine/src/phash"
+)
+
+// DefaultSubstrings is the number of substrings which works well
+// for thresholds up to about 0.2 on millions of hashes
+const DefaultSubstrings = 4
+
+var _ index.SearchIndex[int] = (*MIH[int])(nil)
+
+// MIH implements the Multi-Index Hashing of Norouzi et al. over the
+// PHashes of the points. Each PHash is split into disjoint substrings,
+// and each substring is stored in its own hash table. Two hashes within
+// r bits of each other have at least one substring within r/m bits of
+// each other, where m is the number of substrings, hence the searches
+// only need to probe the buckets close to the substrings of the query.
+type MIH[T comparable] struct {
+	key      func(T) phash.PHash
+	tieBreak func(point1, point2 T) bool
+
+	substrings int
+	// bits is the length of each substring
+	bits int
+	// tables maps the value of each substring to the ids of the points
+	tables []map[uint64][]int
+
+	points []T
+	hashes []phash.PHash
+	live   []bool
+	size   int
+
+	mu sync.RWMutex
+}
+
+// New returns an empty MIH index splitting the PHashes returned by
+// `key` into `substrings` substrings, which must divide phash.Bits
+func New[T comparable](key func(T) phash.PHash, substrings int) (*MIH[T], error) {
+	if substrings < 2 || phash.Bits%substrings != 0 {
+		return nil, errors.New("Invalid number of substrings")
+	}
+	tables := make([]map[uint64][]int, substrings)
+	for i := range tables {
+		tables[i] = make(map[uint64][]int)
+	}
+	return &MIH[T]{
+		key:        key,
+		substrings: substrings,
+		bits:       phash.Bits / substrings,
+		tables:     tables,
+	}, nil
+}
+
+// BuildIndex will return the MIH index holding `points`
+func BuildIndex[T comparable](points []T, key func(T) phash.PHash, substrings int) (*MIH[T], error) {
+	mih, err := New(key, substrings)
+	if err != nil {
+		return nil, err
+	}
+	for _, point := range points {
+		mih.insert(point)
+	}
+	return mih, nil
+}
+
+// SetTieBreaker sets the function ordering the points at the same distance
+// from the query, which otherwise come in the order of their buckets
+func (mih *MIH[T]) SetTieBreaker(less func(point1, point2 T) bool) {
+	mih.mu.Lock()
+	defer mih.mu.Unlock()
+	mih.tieBreak = less
+}
+
+// substring returns the ith substring of `hash`
+func (mih *MIH[T]) substring(hash phash.PHash, i int) uint64 {
+	mask := uint64(1)<<uint(mih.bits) - 1
+	return uint64(hash) >> uint(i*mih.bits) & mask
+}
+
+func (mih *MIH[T]) insert(point T) {
+	id := len(mih.points)
+	hash := mih.key(point)
+	mih.points = append(mih.points, point)
+	mih.hashes = append(mih.hashes, hash)
+	mih.live = append(mih.live, true)
+	mih.size++
+
+	for i, table := range mih.tables {
+		sub := mih.substring(hash, i)
+		table[sub] = append(table[sub], id)
+	}
+}
+
+// Insert adds `point` to the index
+func (mih *MIH[T]) Insert(point T) {
+	mih.mu.Lock()
+	defer mih.mu.Unlock()
+	mih.insert(point)
+}
+
+// Delete removes `point` from the index and reports whether it was found
+func (mih *MIH[T]) Delete(point T) bool {
+	mih.mu.Lock()
+	defer mih.mu.Unlock()
+
+	hash := mih.key(point)
+	for _, id := range mih.tables[0][mih.substring(hash, 0)] {
+		if mih.points[id] != point {
+			continue
+		}
+		for i, table := range mih.tables {
+			sub := mih.substring(hash, i)
+			table[sub] = removeID(table[sub], id)
+			if len(table[sub]) == 0 {
+				delete(table, sub)
+			}
+		}
+		var zero T
+		mih.points[id] = zero
+		mih.live[id] = false
+		mih.size--
+		return true
+	}
+	return false
+}
+
+func removeID(ids []int, id int) []int {
+	for i := range ids {
+		if ids[i] == id {
+			ids[i] = ids[len(ids)-1]
+			return ids[:len(ids)-1]
+		}
+	}
+	return ids
+}
+
+// Len returns the number of points in the index
+func (mih *MIH[T]) Len() int {
+	mih.mu.RLock()
+	defer mih.mu.RUnlock()
+	return mih.size
+}
+
+// Ready reports whether the index holds any point
+func (mih *MIH[T]) Ready() bool {
+	return mih.Len() > 0
+}
+
+// binomial returns the number of ways of choosing k elements among n
+func binomial(n, k int) int {
+	result := 1
+	for i := 1; i <= k; i++ {
+		result = result * (n - k + i) / i
+	}
+	return result
+}
+
+// probeCost returns the number of buckets to probe in order to find all
+// the substrings exactly `radius` bits away from the substrings of a query
+func (mih *MIH[T]) probeCost(radius int) int {
+	return mih.substrings * binomial(mih.bits, radius)
+}
+
+// probe calls `visit` with the ids of the points having at least one
+// substring exactly `radius` bits away from the same substring of `hash`
+func (mih *MIH[T]) probe(hash phash.PHash, radius int, visit func(id int)) {
+	limit := uint64(1) << uint(mih.bits)
+	for i, table := range mih.tables {
+		sub := mih.substring(hash, i)
+		// enumerate the masks with `radius` bits set in increasing order
+		for mask := uint64(1)<<uint(radius) - 1; mask < limit; {
+			for _, id := range table[sub^mask] {
+				visit(id)
+			}
+			if mask == 0 {
+				break
+			}
+			lowest := mask & -mask
+			next := mask + lowest
+			mask = ((next^mask)>>2)/lowest | next
+		}
+	}
+}
+
+// scan calls `visit` with the ids of all the points
+func (mih *MIH[T]) scan(visit func(id int)) {
+	for id, live := range mih.live {
+		if live {
+			visit(id)
+		}
+	}
+}
+
+func (mih *MIH[T]) result(id int, dist int) index.Result[T] {
+	return index.Result[T]{Point: mih.points[id], Distance: float64(dist) / phash.Bits}
+}
+
+// RangeSearch will return all the points within a `threshold` normalized
+// Hamming distance from the given `point`, sorted by ascending distance
+func (mih *MIH[T]) RangeSearch(point T, threshold float64) ([]index.Result[T], error) {
+	if threshold < 0 {
+		return nil, errors.New("Threshold must be positive")
+	}
+	mih.mu.RLock()
+	defer mih.mu.RUnlock()
+
+	hash := mih.key(point)
+	radius := phash.ThresholdBits(threshold)
+	results := make([]index.Result[T], 0)
+	seen := make(map[int]bool)
+
+	visit := func(id int) {
+		if seen[id] {
+			return
+		}
+		seen[id] = true
+		if dist := phash.HammingDist(hash, mih.hashes[id]); dist <= radius {
+			results = append(results, mih.result(id, dist))
+		}
+	}
+
+	subRadius := radius / mih.substrings
+	cost := 0
+	for r := 0; r <= subRadius && r <= mih.bits; r++ {
+		cost += mih.probeCost(r)
+	}
+	if cost > mih.size {
+		// probing would be slower than looking at every point
+		mih.scan(visit)
+	} else {
+		for r := 0; r <= subRadius && r <= mih.bits; r++ {
+			mih.probe(hash, r, visit)
+		}
+	}
+
+	index.SortResults(results, mih.tieBreak)
+	return results, nil
+}
+
+// KNNSearch will return the k nearest neighbours of the given `point`
+// in the index, sorted by ascending distance
+func (mih *MIH[T]) KNNSearch(point T, k uint) ([]index.Result[T], error) {
+	if k < 1 {
+		return nil, errors.New("Invalid k")
+	}
+	mih.mu.RLock()
+	defer mih.mu.RUnlock()
+
+	hash := mih.key(point)
+	candidates := make([]index.Result[T], 0)
+	// counts[d] is the number of candidates at d bits from the query
+	counts := make([]uint, phash.Bits+1)
+	seen := make(map[int]bool)
+
+	visit := func(id int) {
+		if seen[id] {
+			return
+		}
+		seen[id] = true
+		dist := phash.HammingDist(hash, mih.hashes[id])
+		candidates = append(candidates, mih.result(id, dist))
+		counts[dist]++
+	}
+
+	// once the buckets up to `r` bits away are probed, every point within
+	// (r+1)*m-1 bits of the query has been found, since the others differ
+	// by at least r+1 bits in each of their m substrings
+	for r := 0; r <= mih.bits; r++ {
+		if mih.probeCost(r) > mih.size {
+			mih.scan(visit)
+			break
+		}
+		mih.probe(hash, r, visit)
+
+		found := uint(0)
+		for dist := 0; dist < (r+1)*mih.substrings && dist <= phash.Bits; dist++ {
+			found += counts[dist]
+		}
+		if found >= k {
+			break
+		}
+	}
+
+	index.SortResults(candidates, mih.tieBreak)
+	if uint(len(candidates)) > k {
+		candidates = candidates[:k]
+	}
+	return candidates, nil
+}
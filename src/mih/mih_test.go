@@ -0,0 +1,131 @@
+package mih
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	index "github.com/jx3yang/imgsearchengine/src/index"
+	hashtest "github.com/jx3yang/imgsearchengine/src/internal/hashtest"
+	phash "github.com/jx3yang/imgsearchengine/src/phash"
+	vptree "github.com/jx3yang/imgsearchengine/src/vptree"
+)
+
+func TestNew(t *testing.T) {
+	for _, substrings := range []int{0, 1, 3, 5, 128} {
+		// act
+		_, err := New(hashtest.Identity, substrings)
+
+		// assert
+		if err == nil {
+			t.Errorf("New(%d) should fail", substrings)
+		}
+	}
+}
+
+func TestKNNSearch(t *testing.T) {
+	// arrange
+	r := rand.New(rand.NewSource(1))
+	points := hashtest.ClusteredHashes(3000, r)
+	reference := hashtest.Reference(points)
+
+	for _, substrings := range []int{2, 4, 8} {
+		mih, _ := BuildIndex(points, hashtest.Identity, substrings)
+		mih.SetTieBreaker(hashtest.Less)
+
+		for i := 0; i < 20; i++ {
+			point := points[r.Intn(len(points))] ^ phash.PHash(r.Uint64()&r.Uint64()&r.Uint64())
+			k := 1 + r.Intn(20)
+
+			// act
+			got, _ := mih.KNNSearch(point, uint(k))
+
+			// assert
+			want, _ := reference.KNNSearch(point, uint(k))
+			if !reflect.DeepEqual(want, got) {
+				t.Errorf("KNNSearch() with %d substrings = %v, want %v", substrings, got, want)
+			}
+		}
+	}
+}
+
+func TestRangeSearch(t *testing.T) {
+	// arrange
+	r := rand.New(rand.NewSource(2))
+	points := hashtest.ClusteredHashes(3000, r)
+	reference := hashtest.Reference(points)
+
+	for _, substrings := range []int{2, 4, 8} {
+		mih, _ := BuildIndex(points, hashtest.Identity, substrings)
+		mih.SetTieBreaker(hashtest.Less)
+
+		for _, threshold := range []float64{0, 0.05, 0.1, math.Nextafter(6./64, 0), 6. / 64, 0.2, 0.5} {
+			point := points[r.Intn(len(points))]
+
+			// act
+			got, _ := mih.RangeSearch(point, threshold)
+
+			// assert
+			want, _ := reference.RangeSearch(point, threshold)
+			if !reflect.DeepEqual(want, got) {
+				t.Errorf("RangeSearch(%v) with %d substrings = %v, want %v", threshold, substrings, got, want)
+			}
+		}
+	}
+}
+
+func TestDelete(t *testing.T) {
+	// arrange
+	points := []phash.PHash{0b1011, 0b1001, 0b0001, 0b1111, 0b0000}
+	mih, _ := BuildIndex(points, hashtest.Identity, DefaultSubstrings)
+	mih.SetTieBreaker(hashtest.Less)
+
+	want := []index.Result[phash.PHash]{
+		{Point: 0b1001, Distance: 1. / 64},
+		{Point: 0b1111, Distance: 1. / 64},
+	}
+
+	// act
+	deleted := mih.Delete(0b1011)
+	deletedAgain := mih.Delete(0b1011)
+	got, _ := mih.KNNSearch(0b1011, 2)
+
+	// assert
+	if !deleted || deletedAgain {
+		t.Errorf("Delete() = %v then %v, want true then false", deleted, deletedAgain)
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("KNNSearch() = %v, want %v", got, want)
+	}
+	if mih.Len() != len(points)-1 {
+		t.Errorf("Len() = %d, want %d", mih.Len(), len(points)-1)
+	}
+}
+
+// BenchmarkRangeSearch compares the MIH index to the VP-Tree for a tight threshold
+func BenchmarkRangeSearch(b *testing.B) {
+	r := rand.New(rand.NewSource(1))
+	points := hashtest.ClusteredHashes(100000, r)
+	queries := make([]phash.PHash, 200)
+	for i := range queries {
+		queries[i] = points[r.Intn(len(points))] ^ 1<<uint(r.Intn(64))
+	}
+	threshold := 0.1
+
+	mih, _ := BuildIndex(points, hashtest.Identity, DefaultSubstrings)
+	indexes := []struct {
+		name  string
+		index index.SearchIndex[phash.PHash]
+	}{
+		{"mih", mih},
+		{"vptree", vptree.BuildTree(append([]phash.PHash(nil), points...), phash.NormHammingDist)},
+	}
+	for _, idx := range indexes {
+		b.Run(idx.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				idx.index.RangeSearch(queries[i%len(queries)], threshold)
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package phash
+
+import (
+	"image"
+	"image/color"
+	"sort"
+)
+
+const (
+	// side of the grid over which the images are segmented
+	segmentSide = 256
+	// regions smaller than this number of cells of the grid are ignored
+	minSegmentSize = segmentSide * segmentSide / 100
+	// MaxRegions is the maximum number of regions hashed besides the whole image
+	MaxRegions = 16
+	// pixels brighter than this threshold are segmented apart from the others,
+	// a fixed threshold keeps the segments of an image stable under cropping
+	segmentThreshold = 0x7fff
+)
+
+// CropResistantHashes returns the hashes computed by `hasher` of the whole
+// image, first, then of the regions of `img`, from the largest. The image is
+// segmented into the connected regions of bright and of dark pixels, and
+// each region is hashed over its bounding box, hence a cropped copy of the
+// image still shares some regions with it
+func CropResistantHashes(img image.Image, hasher Hasher) ([]PHash, error) {
+	if err := checkImage(img); err != nil {
+		return nil, err
+	}
+	hash, err := hasher.Hash(img)
+	if err != nil {
+		return nil, err
+	}
+	hashes := []PHash{hash}
+
+	bounds := img.Bounds()
+	for _, region := range segment(img) {
+		x0, _ := cellBounds(region.Min.X, segmentSide, bounds.Dx())
+		_, x1 := cellBounds(region.Max.X-1, segmentSide, bounds.Dx())
+		y0, _ := cellBounds(region.Min.Y, segmentSide, bounds.Dy())
+		_, y1 := cellBounds(region.Max.Y-1, segmentSide, bounds.Dy())
+		rect := image.Rect(x0, y0, x1, y1).Add(bounds.Min)
+		if rect.Eq(bounds) || rect.Empty() {
+			continue
+		}
+
+		hash, err := hasher.Hash(cropped(img, rect))
+		if err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, hash)
+	}
+	return hashes, nil
+}
+
+// segment returns the bounding boxes, in cells of the segmentation grid, of
+// the largest connected regions of bright and of dark pixels of `img`
+func segment(img image.Image) []image.Rectangle {
+	values := boxBlur(grayscaleGrid(img, segmentSide), segmentSide)
+	labels := make([]int, len(values))
+	for i := range labels {
+		labels[i] = -1
+	}
+
+	type region struct {
+		size   int
+		bounds image.Rectangle
+	}
+	regions := make([]region, 0)
+	stack := make([]int, 0)
+
+	for start := range values {
+		if labels[start] >= 0 {
+			continue
+		}
+		// flood fill the cells on the same side of the threshold
+		bright := values[start] > segmentThreshold
+		label := len(regions)
+		current := region{bounds: image.Rect(start%segmentSide, start/segmentSide, start%segmentSide+1, start/segmentSide+1)}
+		labels[start] = label
+		stack = append(stack[:0], start)
+
+		for len(stack) > 0 {
+			cell := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			x, y := cell%segmentSide, cell/segmentSide
+			current.size++
+			current.bounds = current.bounds.Union(image.Rect(x, y, x+1, y+1))
+
+			for _, next := range [4][2]int{{x - 1, y}, {x + 1, y}, {x, y - 1}, {x, y + 1}} {
+				nx, ny := next[0], next[1]
+				if nx < 0 || ny < 0 || nx >= segmentSide || ny >= segmentSide {
+					continue
+				}
+				neighbour := ny*segmentSide + nx
+				if labels[neighbour] < 0 && (values[neighbour] > segmentThreshold) == bright {
+					labels[neighbour] = label
+					stack = append(stack, neighbour)
+				}
+			}
+		}
+		regions = append(regions, current)
+	}
+
+	sort.SliceStable(regions, func(i, j int) bool {
+		return regions[i].size > regions[j].size
+	})
+	boxes := make([]image.Rectangle, 0, MaxRegions)
+	for _, region := range regions {
+		if region.size < minSegmentSize || len(boxes) == MaxRegions {
+			break
+		}
+		boxes = append(boxes, region.bounds)
+	}
+	return boxes
+}
+
+// boxBlur averages each value of the `side` x `side` grid with its
+// neighbours, which keeps the noise from splitting the regions
+func boxBlur(values []float64, side int) []float64 {
+	blurred := make([]float64, len(values))
+	for y := 0; y < side; y++ {
+		for x := 0; x < side; x++ {
+			sum, n := 0.0, 0
+			for ny := y - 1; ny <= y+1; ny++ {
+				for nx := x - 1; nx <= x+1; nx++ {
+					if nx >= 0 && ny >= 0 && nx < side && ny < side {
+						sum += values[ny*side+nx]
+						n++
+					}
+				}
+			}
+			blurred[y*side+x] = sum / float64(n)
+		}
+	}
+	return blurred
+}
+
+// cropped returns the part of `img` within `rect`
+func cropped(img image.Image, rect image.Rectangle) image.Image {
+	if sub, ok := img.(interface {
+		SubImage(image.Rectangle) image.Image
+	}); ok {
+		return sub.SubImage(rect)
+	}
+	return &croppedImage{img: img, rect: rect.Intersect(img.Bounds())}
+}
+
+// croppedImage is the view of a part of an image not implementing SubImage
+type croppedImage struct {
+	img  image.Image
+	rect image.Rectangle
+}
+
+func (img *croppedImage) ColorModel() color.Model { return img.img.ColorModel() }
+
+func (img *croppedImage) Bounds() image.Rectangle { return img.rect }
+
+func (img *croppedImage) At(x, y int) color.Color {
+	if !(image.Point{x, y}.In(img.rect)) {
+		return color.Transparent
+	}
+	return img.img.At(x, y)
+}
+
+// MinRegionDist returns the smallest normalized hamming distance between
+// one hash of `hashes1` and one hash of `hashes2`, i.e. the distance of the
+// best matching pair of regions, or 1 if either has no hash
+func MinRegionDist(hashes1, hashes2 []PHash) float64 {
+	best := Bits
+	for _, hash1 := range hashes1 {
+		for _, hash2 := range hashes2 {
+			if dist := hammingDist(hash1, hash2); dist < best {
+				best = dist
+			}
+		}
+	}
+	return float64(best) / Bits
+}
@@ -0,0 +1,99 @@
+package phash
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"math/bits"
+	"strconv"
+	"strings"
+
+	"github.com/corona10/goimagehash"
+)
+
+// ExtPHash is an extended perception hash of Bits() bits, the first word
+// holding the most significant bits. The longer hashes collide less often
+// than the PHashes in large collections.
+type ExtPHash []uint64
+
+// MaxExtBits is the length of the longest extended hashes
+const MaxExtBits = 4096
+
+// Bits returns the number of bits of the hash
+func (hash ExtPHash) Bits() int {
+	return len(hash) * 64
+}
+
+// String returns the hash in hexadecimal with the prefix "0x", see ParseExt
+func (hash ExtPHash) String() string {
+	var sb strings.Builder
+	sb.WriteString("0x")
+	for _, word := range hash {
+		fmt.Fprintf(&sb, "%016x", word)
+	}
+	return sb.String()
+}
+
+// ParseExt returns the extended hash written in hexadecimal, with the
+// optional prefix "0x", where every 16 digits give 64 bits of the hash
+func ParseExt(s string) (ExtPHash, error) {
+	if strings.HasPrefix(strings.ToLower(s), "0x") {
+		s = s[2:]
+	}
+	if len(s) == 0 || len(s)%16 != 0 || len(s)/16*64 > MaxExtBits {
+		return nil, errors.New("Invalid extended hash length")
+	}
+	hash := make(ExtPHash, len(s)/16)
+	for i := range hash {
+		word, err := strconv.ParseUint(s[16*i:16*(i+1)], 16, 64)
+		if err != nil {
+			return nil, err
+		}
+		hash[i] = word
+	}
+	return hash, nil
+}
+
+// GetExtPHash returns the extended PHash of `nbits` bits of a given image,
+// which must be a power of 4 between 64 and MaxExtBits, e.g. 256 or 1024
+func GetExtPHash(img image.Image, nbits int) (ExtPHash, error) {
+	if nbits < Bits || nbits > MaxExtBits || bits.OnesCount(uint(nbits)) != 1 || bits.TrailingZeros(uint(nbits))%2 != 0 {
+		return nil, fmt.Errorf("Invalid number of bits %d", nbits)
+	}
+	if err := checkImage(img); err != nil {
+		return nil, err
+	}
+	side := 1 << uint(bits.TrailingZeros(uint(nbits))/2)
+	hash, err := goimagehash.ExtPerceptionHash(img, side, side)
+	if err != nil {
+		return nil, err
+	}
+	return ExtPHash(hash.GetHash()), nil
+}
+
+// ExtHammingDist returns the number of bits that differ between two
+// extended hashes, which must have the same length
+func ExtHammingDist(hash1, hash2 ExtPHash) (int, error) {
+	if len(hash1) != len(hash2) {
+		return 0, errors.New("Extended hashes of different lengths")
+	}
+	dist := 0
+	for i := range hash1 {
+		dist += bits.OnesCount64(hash1[i] ^ hash2[i])
+	}
+	return dist, nil
+}
+
+// NormExtHammingDist returns the normalized hamming distance between two
+// extended hashes. The hashes of different lengths are at distance 1, the
+// maximum, which keeps it a metric over the hashes of all the lengths.
+func NormExtHammingDist(hash1, hash2 ExtPHash) float64 {
+	dist, err := ExtHammingDist(hash1, hash2)
+	if err != nil {
+		return 1
+	}
+	if len(hash1) == 0 {
+		return 0
+	}
+	return float64(dist) / float64(hash1.Bits())
+}
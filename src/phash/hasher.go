@@ -0,0 +1,259 @@
+package phash
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"sort"
+
+	"github.com/corona10/goimagehash"
+)
+
+// Algorithm identifies the algorithm which computed a PHash, the hashes of
+// different algorithms cannot be compared
+type Algorithm uint8
+
+const (
+	// Perception is the DCT based hash of GetPHash, it is the most robust
+	// to scaling, compression and small edits, and suits photos best
+	Perception Algorithm = iota
+	// Average sets the bits of the pixels of the 8x8 thumbnail brighter
+	// than its mean, it is the fastest and suits screenshots well
+	Average
+	// Difference sets the bits of the pixels of the 9x8 thumbnail brighter
+	// than their right neighbour, it captures the gradients of the image
+	Difference
+	// Wavelet sets the bits of the Haar approximation coefficients
+	// above their median
+	Wavelet
+	// BlockMean sets the bits of the overlapping blocks of the image
+	// brighter than the median block, it is resistant to small shifts
+	BlockMean
+)
+
+var algorithmNames = []string{
+	Perception: "perception",
+	Average:    "average",
+	Difference: "difference",
+	Wavelet:    "wavelet",
+	BlockMean:  "blockmean",
+}
+
+func (alg Algorithm) String() string {
+	if int(alg) < len(algorithmNames) {
+		return algorithmNames[alg]
+	}
+	return fmt.Sprintf("Algorithm(%d)", alg)
+}
+
+// ParseAlgorithm returns the Algorithm with the given name, see String
+func ParseAlgorithm(name string) (Algorithm, error) {
+	for alg, algName := range algorithmNames {
+		if name == algName {
+			return Algorithm(alg), nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown hash algorithm %q", name)
+}
+
+// Hasher computes the PHashes of the images with a given algorithm
+type Hasher interface {
+	Hash(img image.Image) (PHash, error)
+	Algorithm() Algorithm
+}
+
+// NewHasher returns the Hasher of the given algorithm
+func NewHasher(alg Algorithm) (Hasher, error) {
+	switch alg {
+	case Perception:
+		return PerceptionHasher{}, nil
+	case Average:
+		return AverageHasher{}, nil
+	case Difference:
+		return DifferenceHasher{}, nil
+	case Wavelet:
+		return WaveletHasher{}, nil
+	case BlockMean:
+		return BlockMeanHasher{}, nil
+	}
+	return nil, fmt.Errorf("Unknown hash algorithm %d", alg)
+}
+
+var (
+	errNilImage   = errors.New("Image must not be nil")
+	errEmptyImage = errors.New("Image must not be empty")
+)
+
+// checkImage rejects the images which cannot be hashed
+func checkImage(img image.Image) error {
+	if img == nil {
+		return errNilImage
+	}
+	if img.Bounds().Empty() {
+		return errEmptyImage
+	}
+	return nil
+}
+
+// PerceptionHasher computes the hashes of GetPHash
+type PerceptionHasher struct{}
+
+func (PerceptionHasher) Hash(img image.Image) (PHash, error) {
+	return GetPHash(img)
+}
+
+func (PerceptionHasher) Algorithm() Algorithm { return Perception }
+
+// AverageHasher computes the average hashes of the images
+type AverageHasher struct{}
+
+func (AverageHasher) Hash(img image.Image) (PHash, error) {
+	if err := checkImage(img); err != nil {
+		return 0, err
+	}
+	hash, err := goimagehash.AverageHash(img)
+	if err != nil {
+		return 0, err
+	}
+	return PHash(hash.GetHash()), nil
+}
+
+func (AverageHasher) Algorithm() Algorithm { return Average }
+
+// DifferenceHasher computes the difference hashes of the images
+type DifferenceHasher struct{}
+
+func (DifferenceHasher) Hash(img image.Image) (PHash, error) {
+	if err := checkImage(img); err != nil {
+		return 0, err
+	}
+	hash, err := goimagehash.DifferenceHash(img)
+	if err != nil {
+		return 0, err
+	}
+	return PHash(hash.GetHash()), nil
+}
+
+func (DifferenceHasher) Algorithm() Algorithm { return Difference }
+
+// side of the square grids of 64 values thresholded into the hashes
+const hashSide = 8
+
+// WaveletHasher computes the wavelet hashes of the images, the image is
+// reduced to a 64x64 grayscale grid, which the Haar wavelet transform
+// decomposes down to its 8x8 approximation coefficients
+type WaveletHasher struct{}
+
+const waveletSide = 64
+
+func (WaveletHasher) Hash(img image.Image) (PHash, error) {
+	if err := checkImage(img); err != nil {
+		return 0, err
+	}
+	values := grayscaleGrid(img, waveletSide)
+	for side := waveletSide; side > hashSide; side /= 2 {
+		values = haarApproximation(values, side)
+	}
+	return medianHash(values), nil
+}
+
+func (WaveletHasher) Algorithm() Algorithm { return Wavelet }
+
+// haarApproximation returns the approximation coefficients of one level
+// of the 2D Haar transform of the `side` x `side` grid `values`
+func haarApproximation(values []float64, side int) []float64 {
+	half := side / 2
+	coeffs := make([]float64, half*half)
+	for y := 0; y < half; y++ {
+		for x := 0; x < half; x++ {
+			i := 2*y*side + 2*x
+			coeffs[y*half+x] = (values[i] + values[i+1] + values[i+side] + values[i+side+1]) / 2
+		}
+	}
+	return coeffs
+}
+
+// BlockMeanHasher computes the block mean hashes of Yang et al., the image
+// is reduced to a 36x36 grayscale grid split into 8x8 blocks of 8x8 values
+// overlapping by half
+type BlockMeanHasher struct{}
+
+const (
+	blockSide = 8
+	blockStep = blockSide / 2
+	blockGrid = (hashSide-1)*blockStep + blockSide
+)
+
+func (BlockMeanHasher) Hash(img image.Image) (PHash, error) {
+	if err := checkImage(img); err != nil {
+		return 0, err
+	}
+	values := grayscaleGrid(img, blockGrid)
+	means := make([]float64, hashSide*hashSide)
+	for by := 0; by < hashSide; by++ {
+		for bx := 0; bx < hashSide; bx++ {
+			sum := 0.0
+			for y := by * blockStep; y < by*blockStep+blockSide; y++ {
+				for x := bx * blockStep; x < bx*blockStep+blockSide; x++ {
+					sum += values[y*blockGrid+x]
+				}
+			}
+			means[by*hashSide+bx] = sum / (blockSide * blockSide)
+		}
+	}
+	return medianHash(means), nil
+}
+
+func (BlockMeanHasher) Algorithm() Algorithm { return BlockMean }
+
+// grayscaleGrid returns the mean luminance of each cell of the
+// `side` x `side` grid laid over `img`, row by row
+func grayscaleGrid(img image.Image, side int) []float64 {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	values := make([]float64, side*side)
+
+	for cy := 0; cy < side; cy++ {
+		y0, y1 := cellBounds(cy, side, height)
+		for cx := 0; cx < side; cx++ {
+			x0, x1 := cellBounds(cx, side, width)
+			sum := 0.0
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					r, g, b, _ := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+					sum += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+				}
+			}
+			if n := (y1 - y0) * (x1 - x0); n > 0 {
+				values[cy*side+cx] = sum / float64(n)
+			}
+		}
+	}
+	return values
+}
+
+// cellBounds returns the pixels covered by the ith of `cells` cells over
+// `length` pixels, each cell covers at least one pixel of a non empty image
+func cellBounds(i, cells, length int) (int, int) {
+	start, end := i*length/cells, (i+1)*length/cells
+	if end <= start && start < length {
+		end = start + 1
+	}
+	return start, end
+}
+
+// medianHash sets the bits of the 64 `values` above their median,
+// the first value giving the most significant bit as in goimagehash
+func medianHash(values []float64) PHash {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	median := (sorted[len(sorted)/2-1] + sorted[len(sorted)/2]) / 2
+
+	var hash PHash
+	for i, value := range values {
+		if value > median {
+			hash |= 1 << uint(len(values)-1-i)
+		}
+	}
+	return hash
+}
@@ -2,7 +2,10 @@ package phash
 
 import (
 	"image"
+	"math"
 	"math/bits"
+	"strconv"
+	"strings"
 
 	"github.com/corona10/goimagehash"
 )
@@ -11,17 +14,57 @@ import (
 type PHash uint64
 
 // GetPHash returns the signed PHash of a given image
-func GetPHash(img image.Image) PHash {
-	pHash, _ := goimagehash.PerceptionHash(img)
-	return PHash(pHash.GetHash())
+func GetPHash(img image.Image) (PHash, error) {
+	if err := checkImage(img); err != nil {
+		return 0, err
+	}
+	pHash, err := goimagehash.PerceptionHash(img)
+	if err != nil {
+		return 0, err
+	}
+	return PHash(pHash.GetHash()), nil
 }
 
+// Bits is the number of bits of a PHash
+const Bits = 64
+
 func hammingDist(hash1, hash2 PHash) int {
 	xorResult := uint64(hash1) ^ uint64(hash2)
 	return bits.OnesCount64(xorResult)
 }
 
+// HammingDist returns the number of bits that differ between two PHashes
+func HammingDist(hash1, hash2 PHash) int {
+	return hammingDist(hash1, hash2)
+}
+
 // NormHammingDist returns the normalized hamming distance between two PHashes
 func NormHammingDist(hash1, hash2 PHash) float64 {
-	return float64(hammingDist(hash1, hash2)) / 64
+	return float64(hammingDist(hash1, hash2)) / Bits
+}
+
+// ThresholdBits returns the largest number of differing bits within the
+// normalized hamming distance `threshold`, see NormHammingDist. Since the
+// normalization divides by a power of two, which is exact, a distance of
+// n bits is within the threshold exactly when n <= ThresholdBits(threshold),
+// as the indexes comparing the normalized distances decide.
+func ThresholdBits(threshold float64) int {
+	if threshold >= 1 {
+		return Bits
+	}
+	return int(math.Floor(threshold * Bits))
+}
+
+// Parse returns the PHash written in decimal, in hexadecimal
+// with the prefix "0x", or in binary with the prefix "0b"
+func Parse(s string) (PHash, error) {
+	base := 10
+	lower := strings.ToLower(s)
+	if strings.HasPrefix(lower, "0x") {
+		base, s = 16, s[2:]
+	} else if strings.HasPrefix(lower, "0b") {
+		base, s = 2, s[2:]
+	}
+	hash, err := strconv.ParseUint(s, base, Bits)
+	return PHash(hash), err
 }
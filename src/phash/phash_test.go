@@ -1,6 +1,10 @@
 package phash
 
 import (
+	"image"
+	"image/color"
+	"math"
+	"reflect"
 	"strconv"
 	"testing"
 
@@ -27,3 +31,385 @@ func TestHammingDistance(t *testing.T) {
 		t.Errorf("findMedian() = %d, want %d", got, want)
 	}
 }
+
+func TestThresholdBits(t *testing.T) {
+	// not a constant, which would be computed exactly
+	tenth := 0.1
+	tests := []struct {
+		threshold float64
+		want      int
+	}{
+		{0, 0},
+		{0.1, 6},
+		{3. / 64, 3},
+		// just below 43 bits, as the normalized distance of 43 bits is
+		{tenth * 43 / 6.4, 42},
+		{1, 64},
+		{1e300, 64},
+	}
+
+	for _, test := range tests {
+		// act
+		got := ThresholdBits(test.threshold)
+
+		// assert
+		if got != test.want {
+			t.Errorf("ThresholdBits(%v) = %d, want %d", test.threshold, got, test.want)
+		}
+	}
+
+	// the number of bits agrees with the comparison of the normalized distances
+	for i := 0; i <= 6400; i++ {
+		threshold := tenth * float64(i) / 64
+		for bits := 0; bits <= Bits; bits++ {
+			if within := float64(bits)/Bits <= threshold; within != (bits <= ThresholdBits(threshold)) {
+				t.Errorf("ThresholdBits(%v) = %d, but %d bits are within: %v", threshold, ThresholdBits(threshold), bits, within)
+			}
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		input string
+		want  PHash
+		valid bool
+	}{
+		{"42", 42, true},
+		{"042", 42, true},
+		{"18446744073709551615", 1<<64 - 1, true},
+		{"0x2a", 42, true},
+		{"0XFFFFFFFFFFFFFFFF", 1<<64 - 1, true},
+		{"0b101010", 42, true},
+		{"", 0, false},
+		{"0x", 0, false},
+		{"0b102", 0, false},
+		{"-1", 0, false},
+		{"18446744073709551616", 0, false},
+		{"0x1ffffffffffffffff", 0, false},
+	}
+
+	for _, test := range tests {
+		// act
+		got, err := Parse(test.input)
+
+		// assert
+		if (err == nil) != test.valid || (test.valid && got != test.want) {
+			t.Errorf("Parse(%q) = %d, %v, want %d, valid %v", test.input, got, err, test.want, test.valid)
+		}
+	}
+}
+
+// gradient returns a `width` x `height` image whose brightness
+// follows the pattern of `shade`
+func gradient(width, height int, shade func(x, y float64) float64) image.Image {
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			value := shade(float64(x)/float64(width), float64(y)/float64(height))
+			img.SetGray(x, y, color.Gray{Y: uint8(255 * value)})
+		}
+	}
+	return img
+}
+
+func pattern(x, y float64) float64 {
+	return math.Abs(math.Sin(5*x+2*y)) * (1 - y/2)
+}
+
+func TestHashers(t *testing.T) {
+	// arrange
+	img := gradient(300, 200, pattern)
+	resized := gradient(150, 100, pattern)
+	flipped := gradient(300, 200, func(x, y float64) float64 { return pattern(1-x, 1-y) })
+
+	for alg := Perception; alg <= BlockMean; alg++ {
+		hasher, err := NewHasher(alg)
+		if err != nil {
+			t.Fatalf("NewHasher(%v) failed: %v", alg, err)
+		}
+
+		// act
+		hash, errHash := hasher.Hash(img)
+		hashResized, _ := hasher.Hash(resized)
+		hashFlipped, _ := hasher.Hash(flipped)
+		_, errNil := hasher.Hash(nil)
+		_, errEmpty := hasher.Hash(image.NewGray(image.Rect(0, 0, 0, 0)))
+
+		// assert
+		if hasher.Algorithm() != alg {
+			t.Errorf("NewHasher(%v).Algorithm() = %v", alg, hasher.Algorithm())
+		}
+		if errHash != nil {
+			t.Errorf("%v hash failed: %v", alg, errHash)
+		}
+		if dist := HammingDist(hash, hashResized); dist > 6 {
+			t.Errorf("%v hashes of the resized image are %d bits apart", alg, dist)
+		}
+		if dist := HammingDist(hash, hashFlipped); dist < 16 {
+			t.Errorf("%v hashes of the flipped image are %d bits apart", alg, dist)
+		}
+		if errNil == nil || errEmpty == nil {
+			t.Errorf("%v hash of a nil or empty image did not fail", alg)
+		}
+	}
+}
+
+func hashOf(img image.Image) PHash {
+	hash, _ := GetPHash(img)
+	return hash
+}
+
+func TestGetPHashErrors(t *testing.T) {
+	// act
+	_, errNil := GetPHash(nil)
+	_, errEmpty := GetPHash(image.NewGray(image.Rect(0, 0, 0, 0)))
+
+	// assert
+	if errNil == nil {
+		t.Errorf("GetPHash(nil) did not fail")
+	}
+	if errEmpty == nil {
+		t.Errorf("GetPHash() of an empty image did not fail")
+	}
+}
+
+func TestPerceptionHasher(t *testing.T) {
+	// arrange
+	img := gradient(64, 64, pattern)
+
+	// act
+	hash, _ := PerceptionHasher{}.Hash(img)
+
+	// assert
+	if want := hashOf(img); hash != want {
+		t.Errorf("PerceptionHasher.Hash() = %d, want %d", hash, want)
+	}
+}
+
+func TestParseAlgorithm(t *testing.T) {
+	for alg := Perception; alg <= BlockMean; alg++ {
+		// act
+		got, err := ParseAlgorithm(alg.String())
+
+		// assert
+		if err != nil || got != alg {
+			t.Errorf("ParseAlgorithm(%q) = %v, %v, want %v", alg.String(), got, err, alg)
+		}
+	}
+	if _, err := ParseAlgorithm("sha256"); err == nil {
+		t.Errorf("ParseAlgorithm(%q) did not fail", "sha256")
+	}
+}
+
+func TestGetExtPHash(t *testing.T) {
+	// arrange
+	img := gradient(300, 200, pattern)
+	resized := gradient(150, 100, pattern)
+	flipped := gradient(300, 200, func(x, y float64) float64 { return pattern(1-x, 1-y) })
+
+	for _, nbits := range []int{64, 256, 1024} {
+		// act
+		hash, err := GetExtPHash(img, nbits)
+		hashResized, _ := GetExtPHash(resized, nbits)
+		hashFlipped, _ := GetExtPHash(flipped, nbits)
+
+		// assert
+		if err != nil || hash.Bits() != nbits {
+			t.Fatalf("GetExtPHash(%d) = %d bits, %v", nbits, hash.Bits(), err)
+		}
+		if dist := NormExtHammingDist(hash, hashResized); dist > 0.1 {
+			t.Errorf("%d bits hashes of the resized image are at %f", nbits, dist)
+		}
+		if dist := NormExtHammingDist(hash, hashFlipped); dist < 0.25 {
+			t.Errorf("%d bits hashes of the flipped image are at %f", nbits, dist)
+		}
+	}
+
+	if hash, _ := GetExtPHash(img, 64); hash[0] != uint64(hashOf(img)) {
+		t.Errorf("GetExtPHash(64) = %d, want the PHash %d", hash[0], hashOf(img))
+	}
+	for _, nbits := range []int{0, 128, 512, 2 * MaxExtBits} {
+		if _, err := GetExtPHash(img, nbits); err == nil {
+			t.Errorf("GetExtPHash(%d) did not fail", nbits)
+		}
+	}
+}
+
+func TestParseExt(t *testing.T) {
+	// arrange
+	hash := ExtPHash{1<<64 - 1, 42, 0, 1 << 63}
+
+	// act
+	got, err := ParseExt(hash.String())
+
+	// assert
+	if err != nil || !reflect.DeepEqual(got, hash) {
+		t.Errorf("ParseExt(%q) = %v, %v, want %v", hash.String(), got, err, hash)
+	}
+	for _, input := range []string{"", "0x", "0x2a", "0xzzzzzzzzzzzzzzzz"} {
+		if _, err := ParseExt(input); err == nil {
+			t.Errorf("ParseExt(%q) did not fail", input)
+		}
+	}
+}
+
+func TestNormExtHammingDist(t *testing.T) {
+	tests := []struct {
+		hash1, hash2 ExtPHash
+		want         float64
+	}{
+		{ExtPHash{0b1011, 0}, ExtPHash{0b0001, 1}, 3. / 128},
+		{ExtPHash{0b1011, 0}, ExtPHash{0b1011, 0}, 0},
+		{ExtPHash{0b1011, 0}, ExtPHash{0b1011}, 1},
+	}
+
+	for _, test := range tests {
+		// act
+		got := NormExtHammingDist(test.hash1, test.hash2)
+
+		// assert
+		if got != test.want {
+			t.Errorf("NormExtHammingDist(%v, %v) = %f, want %f", test.hash1, test.hash2, got, test.want)
+		}
+	}
+}
+
+func TestTransformed(t *testing.T) {
+	// arrange
+	// 1 2 3
+	// 4 5 6
+	img := image.NewGray(image.Rect(10, 20, 13, 22))
+	copy(img.Pix, []uint8{1, 2, 3, 4, 5, 6})
+
+	tests := []struct {
+		transform Transform
+		want      [][]uint8
+	}{
+		{Identity, [][]uint8{{1, 2, 3}, {4, 5, 6}}},
+		{Rotate90, [][]uint8{{4, 1}, {5, 2}, {6, 3}}},
+		{Rotate180, [][]uint8{{6, 5, 4}, {3, 2, 1}}},
+		{Rotate270, [][]uint8{{3, 6}, {2, 5}, {1, 4}}},
+		{FlipHorizontal, [][]uint8{{3, 2, 1}, {6, 5, 4}}},
+		{FlipVertical, [][]uint8{{4, 5, 6}, {1, 2, 3}}},
+		{Transpose, [][]uint8{{1, 4}, {2, 5}, {3, 6}}},
+		{Transverse, [][]uint8{{6, 3}, {5, 2}, {4, 1}}},
+	}
+
+	for _, test := range tests {
+		// act
+		got := Transformed(img, test.transform)
+
+		// assert
+		bounds := got.Bounds()
+		if bounds.Dx() != len(test.want[0]) || bounds.Dy() != len(test.want) {
+			t.Errorf("Transformed(%v) bounds = %v", test.transform, bounds)
+			continue
+		}
+		for y, row := range test.want {
+			for x, want := range row {
+				if value := color.GrayModel.Convert(got.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.Gray).Y; value != want {
+					t.Errorf("Transformed(%v).At(%d, %d) = %d, want %d", test.transform, x, y, value, want)
+				}
+			}
+		}
+	}
+}
+
+func TestDihedralHashes(t *testing.T) {
+	// arrange
+	img := gradient(120, 80, pattern)
+	mirrored := gradient(120, 80, func(x, y float64) float64 { return pattern(1-x, y) })
+
+	// act
+	hashes, err := DihedralHashes(img, PerceptionHasher{})
+	mirroredHash, _ := GetPHash(mirrored)
+
+	// assert
+	if err != nil || len(hashes) != len(Transforms) {
+		t.Fatalf("DihedralHashes() = %d hashes, %v", len(hashes), err)
+	}
+	if dist := HammingDist(hashes[Identity], mirroredHash); dist < 16 {
+		t.Errorf("the hash of the mirrored image is %d bits away from the original", dist)
+	}
+	if dist := HammingDist(hashes[FlipHorizontal], mirroredHash); dist > 4 {
+		t.Errorf("the hash of the mirrored image is %d bits away from the flipped hash", dist)
+	}
+	if _, err := DihedralHashes(nil, PerceptionHasher{}); err == nil {
+		t.Errorf("DihedralHashes(nil) did not fail")
+	}
+}
+
+// blobs returns an image of textured bright discs over a textured background
+func blobs(width, height int) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	discs := [][3]float64{{0.25, 0.3, 0.15}, {0.7, 0.35, 0.12}, {0.4, 0.75, 0.18}, {0.8, 0.8, 0.1}}
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			fx, fy := float64(x)/float64(width), float64(y)/float64(height)
+			value := 40 + 30*math.Sin(9*fx+4*fy)
+			for i, disc := range discs {
+				if math.Hypot(fx-disc[0], fy-disc[1]) < disc[2] {
+					value = 190 + 50*math.Sin(float64(i+3)*(13*fx+7*fy))
+				}
+			}
+			img.SetGray(x, y, color.Gray{Y: uint8(value)})
+		}
+	}
+	return img
+}
+
+func TestCropResistantHashes(t *testing.T) {
+	// arrange
+	img := blobs(400, 400)
+	crop := img.SubImage(image.Rect(0, 0, 240, 260))
+	other := gradient(400, 400, pattern)
+
+	for _, hasher := range []Hasher{PerceptionHasher{}, DifferenceHasher{}} {
+		// act
+		hashes, err := CropResistantHashes(img, hasher)
+		cropHashes, _ := CropResistantHashes(crop, hasher)
+		otherHashes, _ := CropResistantHashes(other, hasher)
+
+		// assert
+		if err != nil || len(hashes) < 2 {
+			t.Fatalf("CropResistantHashes(%v) = %d hashes, %v", hasher.Algorithm(), len(hashes), err)
+		}
+		if want, _ := hasher.Hash(img); hashes[0] != want {
+			t.Errorf("CropResistantHashes(%v) starts with %d, want the hash of the image %d", hasher.Algorithm(), hashes[0], want)
+		}
+		if dist := HammingDist(hashes[0], cropHashes[0]); dist < 16 {
+			t.Errorf("%v hashes of the cropped image are %d bits apart", hasher.Algorithm(), dist)
+		}
+		if dist := MinRegionDist(hashes, cropHashes); dist > 10./64 {
+			t.Errorf("%v best regions of the cropped image are at %f", hasher.Algorithm(), dist)
+		}
+		if dist := MinRegionDist(hashes, otherHashes); dist < 16./64 {
+			t.Errorf("%v best regions of another image are at %f", hasher.Algorithm(), dist)
+		}
+		if _, err := CropResistantHashes(Transformed(img, Rotate90), hasher); err != nil {
+			t.Errorf("CropResistantHashes(%v) of a transformed image failed: %v", hasher.Algorithm(), err)
+		}
+	}
+}
+
+func TestMinRegionDist(t *testing.T) {
+	tests := []struct {
+		hashes1, hashes2 []PHash
+		want             float64
+	}{
+		{[]PHash{0b1111, 0b0001}, []PHash{0b0000, 0b1110}, 1. / 64},
+		{[]PHash{0b1111}, []PHash{0b1111, 0}, 0},
+		{[]PHash{0b1111}, nil, 1},
+	}
+
+	for _, test := range tests {
+		// act
+		got := MinRegionDist(test.hashes1, test.hashes2)
+
+		// assert
+		if got != test.want {
+			t.Errorf("MinRegionDist(%v, %v) = %f, want %f", test.hashes1, test.hashes2, got, test.want)
+		}
+	}
+}
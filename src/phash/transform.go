@@ -0,0 +1,129 @@
+package phash
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+)
+
+// Transform is one of the 8 rotations and reflections of an image, i.e.
+// the dihedral group of the square
+type Transform uint8
+
+const (
+	// Identity leaves the image unchanged
+	Identity Transform = iota
+	// Rotate90 rotates the image by 90° clockwise
+	Rotate90
+	Rotate180
+	// Rotate270 rotates the image by 90° counterclockwise
+	Rotate270
+	// FlipHorizontal mirrors the left and the right of the image
+	FlipHorizontal
+	// FlipVertical mirrors the top and the bottom of the image
+	FlipVertical
+	// Transpose mirrors the image over its main diagonal
+	Transpose
+	// Transverse mirrors the image over its anti-diagonal
+	Transverse
+)
+
+// Transforms holds all the transforms, starting with the Identity
+var Transforms = []Transform{
+	Identity, Rotate90, Rotate180, Rotate270,
+	FlipHorizontal, FlipVertical, Transpose, Transverse,
+}
+
+var transformNames = []string{
+	Identity:       "identity",
+	Rotate90:       "rotate90",
+	Rotate180:      "rotate180",
+	Rotate270:      "rotate270",
+	FlipHorizontal: "fliph",
+	FlipVertical:   "flipv",
+	Transpose:      "transpose",
+	Transverse:     "transverse",
+}
+
+func (t Transform) String() string {
+	if int(t) < len(transformNames) {
+		return transformNames[t]
+	}
+	return fmt.Sprintf("Transform(%d)", t)
+}
+
+// swapsAxes reports whether the transform swaps the width and the height
+func (t Transform) swapsAxes() bool {
+	return t == Rotate90 || t == Rotate270 || t == Transpose || t == Transverse
+}
+
+// transformed is a view of an image through a transform,
+// the pixels are mapped on access without copying the image
+type transformed struct {
+	img       image.Image
+	transform Transform
+}
+
+// Transformed returns the image `img` seen through the transform `t`
+func Transformed(img image.Image, t Transform) image.Image {
+	if t == Identity {
+		return img
+	}
+	return &transformed{img: img, transform: t}
+}
+
+func (img *transformed) ColorModel() color.Model {
+	return img.img.ColorModel()
+}
+
+func (img *transformed) Bounds() image.Rectangle {
+	size := img.img.Bounds().Size()
+	if img.transform.swapsAxes() {
+		return image.Rect(0, 0, size.Y, size.X)
+	}
+	return image.Rect(0, 0, size.X, size.Y)
+}
+
+func (img *transformed) At(x, y int) color.Color {
+	bounds := img.img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+
+	var sx, sy int
+	switch img.transform {
+	case Rotate90:
+		sx, sy = y, h-1-x
+	case Rotate180:
+		sx, sy = w-1-x, h-1-y
+	case Rotate270:
+		sx, sy = w-1-y, x
+	case FlipHorizontal:
+		sx, sy = w-1-x, y
+	case FlipVertical:
+		sx, sy = x, h-1-y
+	case Transpose:
+		sx, sy = y, x
+	case Transverse:
+		sx, sy = w-1-y, h-1-x
+	default:
+		sx, sy = x, y
+	}
+	return img.img.At(bounds.Min.X+sx, bounds.Min.Y+sy)
+}
+
+// DihedralHashes returns the hashes computed by `hasher` of the 8
+// transforms of `img`, in the order of Transforms. A rotated or mirrored
+// copy of `img` has one of them as its own hash.
+func DihedralHashes(img image.Image, hasher Hasher) ([]PHash, error) {
+	if err := checkImage(img); err != nil {
+		return nil, err
+	}
+	hashes := make([]PHash, len(Transforms))
+	for i, t := range Transforms {
+		hash, err := hasher.Hash(Transformed(img, t))
+		if err != nil {
+			return nil, err
+		}
+		hashes[i] = hash
+	}
+	return hashes, nil
+}
@@ -0,0 +1,195 @@
+package vptree
+
+import (
+	"math/rand"
+	"runtime"
+	"sort"
+	"sync"
+)
+
+const (
+	// subsets of points smaller than this are handled by a single goroutine
+	parallelThreshold = 4096
+	// number of candidates, and of points they are compared to,
+	// sampled by the MaxSpread selection
+	spreadSampleSize = 16
+)
+
+// Selection is a strategy for choosing the vantage point of each node
+type Selection int
+
+const (
+	// FarthestPoint picks the point furthest from the vantage point of
+	// the parent node, and a random point for the root
+	FarthestPoint Selection = iota
+	// RandomPoint picks a random point
+	RandomPoint
+	// MaxSpread samples a few candidates and picks the one whose distances
+	// to a sample of the points have the largest spread around their median,
+	// as described in Yianilos' paper
+	MaxSpread
+)
+
+// BuildOption configures the construction of a VP-Tree
+type BuildOption func(*buildConfig)
+
+type buildConfig struct {
+	workers   int
+	selection Selection
+}
+
+func defaultBuildConfig() buildConfig {
+	return buildConfig{
+		workers:   runtime.GOMAXPROCS(0),
+		selection: FarthestPoint,
+	}
+}
+
+// WithWorkers sets the maximum number of goroutines building the tree.
+// It defaults to GOMAXPROCS, 1 builds the tree on the calling goroutine.
+func WithWorkers(workers int) BuildOption {
+	return func(config *buildConfig) {
+		if workers > 0 {
+			config.workers = workers
+		}
+	}
+}
+
+// WithSelection sets the strategy for choosing the vantage points,
+// it defaults to FarthestPoint
+func WithSelection(selection Selection) BuildOption {
+	return func(config *buildConfig) {
+		config.selection = selection
+	}
+}
+
+// builder builds the subtrees and computes the distances to the vantage
+// points concurrently, using at most `workers` goroutines
+type builder[T comparable] struct {
+	distanceFnc DistanceFnc[T]
+	selection   Selection
+	// a token is taken from sem for every extra goroutine
+	sem chan struct{}
+}
+
+func newBuilder[T comparable](distanceFnc DistanceFnc[T], config buildConfig) *builder[T] {
+	workers := config.workers
+	if workers < 1 {
+		workers = 1
+	}
+	return &builder[T]{
+		distanceFnc: distanceFnc,
+		selection:   config.selection,
+		sem:         make(chan struct{}, workers-1),
+	}
+}
+
+// selectVantagePoint moves the vantage point of `points` to the end of the slice
+func (b *builder[T]) selectVantagePoint(points []T) {
+	last := len(points) - 1
+	idx := last
+
+	switch b.selection {
+	case RandomPoint:
+		idx = rand.Intn(len(points))
+	case MaxSpread:
+		if len(points) > spreadSampleSize {
+			idx = b.maxSpread(points)
+		}
+	}
+
+	points[idx], points[last] = points[last], points[idx]
+}
+
+// maxSpread returns the index of the sampled candidate whose distances
+// to a sample of `points` have the largest second moment about their median
+func (b *builder[T]) maxSpread(points []T) int {
+	sample := make([]T, spreadSampleSize)
+	for i := range sample {
+		sample[i] = points[rand.Intn(len(points))]
+	}
+
+	distances := make([]float64, spreadSampleSize)
+	bestIdx, bestSpread := len(points)-1, -1.
+
+	for c := 0; c < spreadSampleSize; c++ {
+		idx := rand.Intn(len(points))
+		for i, point := range sample {
+			distances[i] = b.distanceFnc(points[idx], point)
+		}
+		sort.Float64s(distances)
+		median := distances[len(distances)/2]
+
+		spread := 0.
+		for _, dist := range distances {
+			spread += (dist - median) * (dist - median)
+		}
+		if spread > bestSpread {
+			bestIdx, bestSpread = idx, spread
+		}
+	}
+
+	return bestIdx
+}
+
+// fork runs `task` on a new goroutine if a worker is available, or on
+// the current goroutine otherwise. `wg` is done once `task` returns.
+func (b *builder[T]) fork(wg *sync.WaitGroup, task func()) {
+	wg.Add(1)
+	select {
+	case b.sem <- struct{}{}:
+		go func() {
+			defer wg.Done()
+			defer func() { <-b.sem }()
+			task()
+		}()
+	default:
+		defer wg.Done()
+		task()
+	}
+}
+
+// distances returns the distances between `vantagePoint` and `points`
+func (b *builder[T]) distances(vantagePoint T, points []T) []float64 {
+	distances := make([]float64, len(points))
+
+	var wg sync.WaitGroup
+	for start := 0; start < len(points); start += parallelThreshold {
+		end := start + parallelThreshold
+		if end > len(points) {
+			end = len(points)
+		}
+		chunk := points[start:end]
+		chunkDistances := distances[start:end]
+		task := func() {
+			for i, point := range chunk {
+				chunkDistances[i] = b.distanceFnc(vantagePoint, point)
+			}
+		}
+
+		// the current goroutine handles the last chunk
+		if end == len(points) {
+			task()
+		} else {
+			b.fork(&wg, task)
+		}
+	}
+	wg.Wait()
+
+	return distances
+}
+
+// buildChildren builds the children of `node`, concurrently if both
+// of them are large enough
+func (b *builder[T]) buildChildren(node *VPNode[T], leftPoints, rightPoints []T) {
+	if len(leftPoints) < parallelThreshold || len(rightPoints) < parallelThreshold {
+		node.Left = b.build(leftPoints)
+		node.Right = b.build(rightPoints)
+		return
+	}
+
+	var wg sync.WaitGroup
+	b.fork(&wg, func() { node.Left = b.build(leftPoints) })
+	node.Right = b.build(rightPoints)
+	wg.Wait()
+}
@@ -0,0 +1,432 @@
+package vptree
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"unsafe"
+
+	"github.com/ef-ds/deque"
+
+	index "github.com/jx3yang/imgsearchengine/src/index"
+)
+
+// Layout of a flat snapshot, all integers are little endian and every
+// section starts on an 8 bytes boundary so that it can be used in place
+// once memory-mapped:
+//
+//	magic       [4]byte  "VPTF"
+//	version     uint16
+//	reserved    uint16
+//	count       uint64   number of nodes
+//	payloadSize uint64
+//	hashes      count uint64
+//	bounds      count times 4 float64 (LeftMin, LeftMax, RightMin, RightMax)
+//	children    count times 2 int32 (left, right), -1 when there is no child
+//	flags       count uint8, padded to 8 bytes (deleted)
+//	offsets     count+1 uint64, offsets of the payloads
+//	payloads    payloadSize bytes
+//	checksum    uint32   CRC-32 (IEEE) of everything above
+const (
+	flatMagic      = "VPTF"
+	flatVersion    = 1
+	flatHeaderSize = 24
+)
+
+// HashDistanceFnc is a function that computes the distance between two hashes
+// Requires: HashDistanceFnc(hash1, hash2) >= 0
+type HashDistanceFnc func(hash1, hash2 uint64) float64
+
+// FlatTree is a VP-Tree over uint64 hashes whose nodes are stored in
+// contiguous arrays instead of being linked by pointers. Each node may
+// carry an opaque payload (e.g. the path of the image). A FlatTree is
+// read-only, and can be queried directly from a memory-mapped snapshot.
+type FlatTree struct {
+	hashes      []uint64
+	bounds      []float64
+	children    []int32
+	flags       []uint8
+	offsets     []uint64
+	payloads    []byte
+	distanceFnc HashDistanceFnc
+
+	// backing memory of the arrays when opened from a file
+	data  []byte
+	unmap func([]byte) error
+}
+
+// NewFlatTree lays out the nodes of `tree` in contiguous arrays, using `key`
+// to compute the hash of each vantage point and `payload` to compute
+// the data stored along with it. `payload` may be nil.
+func NewFlatTree[T comparable](tree *VPTree[T], key func(T) uint64, payload func(T) []byte, distanceFnc HashDistanceFnc) *FlatTree {
+	tree.mu.RLock()
+	defer tree.mu.RUnlock()
+
+	count := tree.Root.subtreeSize()
+	flat := &FlatTree{
+		hashes:      make([]uint64, 0, count),
+		bounds:      make([]float64, 0, 4*count),
+		children:    make([]int32, 0, 2*count),
+		flags:       make([]uint8, 0, count),
+		offsets:     make([]uint64, 1, count+1),
+		payloads:    make([]byte, 0),
+		distanceFnc: distanceFnc,
+	}
+
+	var walk func(*VPNode[T]) int32
+	walk = func(node *VPNode[T]) int32 {
+		if node == nil {
+			return -1
+		}
+		idx := int32(len(flat.hashes))
+		flat.hashes = append(flat.hashes, key(node.VantagePoint))
+		flat.bounds = append(flat.bounds, node.LeftMin, node.LeftMax, node.RightMin, node.RightMax)
+		flat.children = append(flat.children, -1, -1)
+		var flags uint8
+		if node.Deleted {
+			flags |= flagDeleted
+		}
+		flat.flags = append(flat.flags, flags)
+		if payload != nil {
+			flat.payloads = append(flat.payloads, payload(node.VantagePoint)...)
+		}
+		flat.offsets = append(flat.offsets, uint64(len(flat.payloads)))
+
+		left := walk(node.Left)
+		right := walk(node.Right)
+		flat.children[2*idx] = left
+		flat.children[2*idx+1] = right
+		return idx
+	}
+	walk(tree.Root)
+
+	return flat
+}
+
+// Len returns the number of nodes of the FlatTree, including deleted ones
+func (tree *FlatTree) Len() int { return len(tree.hashes) }
+
+// Hash returns the hash stored in the node `idx`
+func (tree *FlatTree) Hash(idx int) uint64 { return tree.hashes[idx] }
+
+// Payload returns the payload stored in the node `idx`. When the tree
+// is memory-mapped, the returned slice is only valid until Close.
+func (tree *FlatTree) Payload(idx int) []byte {
+	return tree.payloads[tree.offsets[idx]:tree.offsets[idx+1]]
+}
+
+func (tree *FlatTree) deleted(idx int32) bool { return tree.flags[idx]&flagDeleted != 0 }
+
+// before orders the nodes at the same distance from the query by
+// hash, then by position in the tree
+func (tree *FlatTree) before(idx1, idx2 int32) bool {
+	if tree.hashes[idx1] != tree.hashes[idx2] {
+		return tree.hashes[idx1] < tree.hashes[idx2]
+	}
+	return idx1 < idx2
+}
+
+// results converts the nodes found by a search to results sorted by
+// ascending distance, then by hash
+func (tree *FlatTree) results(found []kvp[int32]) []index.Result[int] {
+	results := make([]index.Result[int], len(found))
+	for i, pair := range found {
+		results[i] = index.Result[int]{Point: int(pair.value), Distance: pair.key}
+	}
+	index.SortResults(results, func(idx1, idx2 int) bool { return tree.before(int32(idx1), int32(idx2)) })
+	return results
+}
+
+func padding(n int) int { return (8 - n%8) % 8 }
+
+// WriteFlat writes the FlatTree to `w` in a format that OpenFlat can memory-map
+func (tree *FlatTree) WriteFlat(w io.Writer) error {
+	checksum := crc32.NewIEEE()
+	bw := bufio.NewWriter(io.MultiWriter(w, checksum))
+
+	count := len(tree.hashes)
+	bw.WriteString(flatMagic)
+	binary.Write(bw, binary.LittleEndian, uint16(flatVersion))
+	binary.Write(bw, binary.LittleEndian, uint16(0))
+	binary.Write(bw, binary.LittleEndian, uint64(count))
+	binary.Write(bw, binary.LittleEndian, uint64(len(tree.payloads)))
+
+	binary.Write(bw, binary.LittleEndian, tree.hashes)
+	binary.Write(bw, binary.LittleEndian, tree.bounds)
+	binary.Write(bw, binary.LittleEndian, tree.children)
+	bw.Write(tree.flags)
+	bw.Write(make([]byte, padding(count)))
+	binary.Write(bw, binary.LittleEndian, tree.offsets)
+	bw.Write(tree.payloads)
+
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	return binary.Write(w, binary.LittleEndian, checksum.Sum32())
+}
+
+var nativeLittleEndian = func() bool {
+	x := uint16(1)
+	return *(*byte)(unsafe.Pointer(&x)) == 1
+}()
+
+// flatSections splits a flat snapshot into its sections, or returns
+// false if the size of `data` does not match its header
+func flatSections(data []byte) (sections [6][]byte, ok bool) {
+	if len(data) < flatHeaderSize+4 || string(data[:len(flatMagic)]) != flatMagic {
+		return sections, false
+	}
+	if binary.LittleEndian.Uint16(data[len(flatMagic):]) != flatVersion {
+		return sections, false
+	}
+	count := binary.LittleEndian.Uint64(data[8:])
+	payloadSize := binary.LittleEndian.Uint64(data[16:])
+	if count > uint64(len(data)) || payloadSize > uint64(len(data)) {
+		return sections, false
+	}
+
+	n := int(count)
+	sizes := [6]int{8 * n, 32 * n, 8 * n, n + padding(n), 8 * (n + 1), int(payloadSize)}
+	offset := flatHeaderSize
+	for i, size := range sizes {
+		if offset+size > len(data)-4 {
+			return sections, false
+		}
+		sections[i] = data[offset : offset+size]
+		offset += size
+	}
+	sections[3] = sections[3][:n]
+
+	return sections, offset == len(data)-4
+}
+
+// castSection reinterprets a section of a snapshot as an array of E,
+// either in place or by decoding a copy of it
+func castSection[E uint64 | float64 | int32](section []byte, inPlace bool) []E {
+	var e E
+	n := len(section) / int(unsafe.Sizeof(e))
+	if n == 0 {
+		return nil
+	}
+	if inPlace {
+		return unsafe.Slice((*E)(unsafe.Pointer(&section[0])), n)
+	}
+	decoded := make([]E, n)
+	binary.Read(bytes.NewReader(section), binary.LittleEndian, decoded)
+	return decoded
+}
+
+// viewFlat builds a FlatTree whose arrays point directly into `data`
+// when possible, or into decoded copies otherwise
+func viewFlat(data []byte, distanceFnc HashDistanceFnc) (*FlatTree, error) {
+	sections, ok := flatSections(data)
+	if !ok {
+		return nil, ErrInvalidSnapshot
+	}
+
+	inPlace := nativeLittleEndian && uintptr(unsafe.Pointer(&data[0]))%8 == 0
+	tree := &FlatTree{
+		hashes:      castSection[uint64](sections[0], inPlace),
+		bounds:      castSection[float64](sections[1], inPlace),
+		children:    castSection[int32](sections[2], inPlace),
+		flags:       sections[3],
+		offsets:     castSection[uint64](sections[4], inPlace),
+		payloads:    sections[5],
+		distanceFnc: distanceFnc,
+		data:        data,
+	}
+
+	// make sure that the children and payloads are within bounds, so
+	// that a corrupted snapshot cannot make the searches panic or loop.
+	// The nodes are in pre-order, hence a child comes after its parent.
+	count := int32(len(tree.hashes))
+	for i, child := range tree.children {
+		if child != -1 && (child <= int32(i/2) || child >= count) {
+			return nil, ErrInvalidSnapshot
+		}
+	}
+	for i := 1; i < len(tree.offsets); i++ {
+		if tree.offsets[i] < tree.offsets[i-1] || tree.offsets[i] > uint64(len(tree.payloads)) {
+			return nil, ErrInvalidSnapshot
+		}
+	}
+
+	return tree, nil
+}
+
+// OpenFlat memory-maps the flat snapshot at `path` written by WriteFlat.
+// The checksum of the snapshot is verified, which reads it whole once, then
+// the nodes are paged in by the OS as the searches visit them. The tree
+// must be closed once it is not used anymore.
+func OpenFlat(path string, distanceFnc HashDistanceFnc) (*FlatTree, error) {
+	return openFlat(path, distanceFnc, true)
+}
+
+// OpenFlatUnverified is OpenFlat without verifying the checksum, for the
+// snapshots too large to be read whole on opening. The structure of the
+// snapshot is still checked, so a corrupted snapshot cannot make the
+// searches panic, but it may return wrong results.
+func OpenFlatUnverified(path string, distanceFnc HashDistanceFnc) (*FlatTree, error) {
+	return openFlat(path, distanceFnc, false)
+}
+
+func openFlat(path string, distanceFnc HashDistanceFnc, verify bool) (*FlatTree, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	data, unmap, err := mapFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	tree, err := viewFlat(data, distanceFnc)
+	if err == nil && verify && !tree.Verify() {
+		err = ErrInvalidSnapshot
+	}
+	if err != nil {
+		unmap(data)
+		return nil, err
+	}
+	tree.unmap = unmap
+	return tree, nil
+}
+
+// ReadFlat reads a flat snapshot written by WriteFlat into memory
+func ReadFlat(r io.Reader, distanceFnc HashDistanceFnc) (*FlatTree, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := viewFlat(data, distanceFnc)
+	if err != nil {
+		return nil, err
+	}
+	if !tree.Verify() {
+		return nil, ErrInvalidSnapshot
+	}
+	return tree, nil
+}
+
+// Verify checks the checksum of a FlatTree opened from a snapshot,
+// which reads the whole snapshot, see OpenFlatUnverified
+func (tree *FlatTree) Verify() bool {
+	if tree.data == nil {
+		return true
+	}
+	n := len(tree.data) - 4
+	return crc32.ChecksumIEEE(tree.data[:n]) == binary.LittleEndian.Uint32(tree.data[n:])
+}
+
+// Close releases the memory-mapped snapshot backing the FlatTree
+func (tree *FlatTree) Close() error {
+	if tree.unmap == nil {
+		return nil
+	}
+	err := tree.unmap(tree.data)
+	*tree = FlatTree{}
+	return err
+}
+
+// KNNSearch will return the k nearest neighbours of the given `hash`
+// in the FlatTree as the indices of their nodes, sorted by ascending distance
+func (tree *FlatTree) KNNSearch(hash uint64, k uint) ([]index.Result[int], error) {
+	if k < 1 {
+		return nil, errors.New("Invalid k")
+	}
+
+	if len(tree.hashes) == 0 {
+		return make([]index.Result[int], 0), nil
+	}
+
+	nodesToVisit := deque.New()
+	nodesToVisit.PushFront(kvp[int32]{0, 0})
+
+	tau := math.MaxFloat64
+
+	results := &heap[int32]{tieBreak: tree.before}
+
+	for nodesToVisit.Len() > 0 {
+		pair, _ := nodesToVisit.PopFront()
+		kvpObj := pair.(kvp[int32])
+		d0, idx := kvpObj.key, kvpObj.value
+		if idx < 0 || d0 > tau {
+			continue
+		}
+		dist := tree.distanceFnc(hash, tree.hashes[idx])
+
+		if dist <= tau && !tree.deleted(idx) {
+			results.Offer(kvp[int32]{dist, idx}, k)
+			if uint(results.Len()) == k {
+				tau = results.Root()
+			}
+		}
+
+		tree.pushChildren(nodesToVisit, idx, dist, tau)
+	}
+
+	return tree.results(results.Data), nil
+}
+
+// RangeSearch will return all the nodes within a `threshold` distance from
+// the given `hash` as the indices of the nodes, sorted by ascending distance
+func (tree *FlatTree) RangeSearch(hash uint64, threshold float64) ([]index.Result[int], error) {
+	if threshold < 0 {
+		return nil, errors.New("Threshold must be positive")
+	}
+
+	if len(tree.hashes) == 0 {
+		return make([]index.Result[int], 0), nil
+	}
+	found := make([]kvp[int32], 0)
+
+	nodesToVisit := deque.New()
+	nodesToVisit.PushFront(kvp[int32]{0, 0})
+
+	for nodesToVisit.Len() > 0 {
+		pair, _ := nodesToVisit.PopFront()
+		kvpObj := pair.(kvp[int32])
+		d0, idx := kvpObj.key, kvpObj.value
+		if idx < 0 || d0 > threshold {
+			continue
+		}
+
+		dist := tree.distanceFnc(hash, tree.hashes[idx])
+		if dist <= threshold && !tree.deleted(idx) {
+			found = append(found, kvp[int32]{dist, idx})
+		}
+
+		tree.pushChildren(nodesToVisit, idx, dist, threshold)
+	}
+
+	return tree.results(found), nil
+}
+
+// pushChildren queues the children of the node `idx` which may contain
+// points within `tau` of the query, at distance `dist` of the node
+func (tree *FlatTree) pushChildren(nodesToVisit *deque.Deque, idx int32, dist float64, tau float64) {
+	for side := 0; side < 2; side++ {
+		child := tree.children[2*idx+int32(side)]
+		if child < 0 {
+			continue
+		}
+		minDist, maxDist := tree.bounds[4*idx+2*int32(side)], tree.bounds[4*idx+2*int32(side)+1]
+
+		if minDist <= dist && dist <= maxDist {
+			nodesToVisit.PushFront(kvp[int32]{0, child})
+		} else if minDist-tau <= dist && dist <= maxDist+tau {
+			if dist < minDist {
+				nodesToVisit.PushBack(kvp[int32]{minDist - dist, child})
+			} else {
+				nodesToVisit.PushBack(kvp[int32]{dist - maxDist, child})
+			}
+		}
+	}
+}
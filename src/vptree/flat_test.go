@@ -0,0 +1,138 @@
+package vptree
+
+import (
+	"bytes"
+	"math/bits"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+
+	index "github.com/jx3yang/imgsearchengine/src/index"
+)
+
+func hammingDist(hash1, hash2 uint64) float64 {
+	return float64(bits.OnesCount64(hash1^hash2)) / 64
+}
+
+func buildHashTree(n int) *VPTree[uint64] {
+	r := rand.New(rand.NewSource(42))
+	points := make([]uint64, n)
+	for i := range points {
+		points[i] = r.Uint64()
+	}
+	tree := BuildTree(points, hammingDist)
+	tree.SetTieBreaker(func(hash1, hash2 uint64) bool { return hash1 < hash2 })
+	tree.Delete(points[0])
+	return tree
+}
+
+func byHash(flat *FlatTree, results []index.Result[int]) []index.Result[uint64] {
+	hashes := make([]index.Result[uint64], len(results))
+	for i, result := range results {
+		hashes[i] = index.Result[uint64]{Point: flat.Hash(result.Point), Distance: result.Distance}
+	}
+	return hashes
+}
+
+func TestFlatTreeSearch(t *testing.T) {
+	// arrange
+	tree := buildHashTree(2000)
+	payload := func(hash uint64) []byte { return []byte(strconv.FormatUint(hash, 10)) }
+	identity := func(hash uint64) uint64 { return hash }
+
+	path := filepath.Join(t.TempDir(), "tree.flat")
+	file, _ := os.Create(path)
+	NewFlatTree(tree, identity, payload, hammingDist).WriteFlat(file)
+	file.Close()
+
+	// act
+	flat, err := OpenFlat(path, hammingDist)
+	if err != nil {
+		t.Fatalf("OpenFlat() = %v", err)
+	}
+	defer flat.Close()
+
+	// assert
+	if !flat.Verify() {
+		t.Errorf("Verify() = false, want true")
+	}
+	r := rand.New(rand.NewSource(7))
+	for i := 0; i < 20; i++ {
+		point := r.Uint64()
+
+		wantKNN, _ := tree.KNNSearch(point, 10)
+		gotKNN, _ := flat.KNNSearch(point, 10)
+		if !reflect.DeepEqual(wantKNN, byHash(flat, gotKNN)) {
+			t.Errorf("KNNSearch() want and got not equal")
+		}
+
+		wantRange, _ := tree.RangeSearch(point, 0.35)
+		gotRange, _ := flat.RangeSearch(point, 0.35)
+		if !reflect.DeepEqual(wantRange, byHash(flat, gotRange)) {
+			t.Errorf("RangeSearch() want and got not equal")
+		}
+
+		for _, result := range gotRange {
+			idx := result.Point
+			if string(flat.Payload(idx)) != strconv.FormatUint(flat.Hash(idx), 10) {
+				t.Errorf("Payload(%d) = %s, want %d", idx, flat.Payload(idx), flat.Hash(idx))
+			}
+		}
+	}
+}
+
+func TestFlatTreeCorrupted(t *testing.T) {
+	// arrange
+	tree := buildHashTree(100)
+	identity := func(hash uint64) uint64 { return hash }
+
+	var buf bytes.Buffer
+	NewFlatTree(tree, identity, nil, hammingDist).WriteFlat(&buf)
+	data := buf.Bytes()
+
+	// act
+	_, errTruncated := ReadFlat(bytes.NewReader(data[:len(data)-8]), hammingDist)
+	data[flatHeaderSize+3] ^= 0xff
+	_, errFlipped := ReadFlat(bytes.NewReader(data), hammingDist)
+
+	// assert
+	if errTruncated == nil {
+		t.Errorf("ReadFlat() accepted a truncated snapshot")
+	}
+	if errFlipped == nil {
+		t.Errorf("ReadFlat() accepted a corrupted snapshot")
+	}
+}
+
+func TestOpenFlatCorrupted(t *testing.T) {
+	// arrange
+	tree := buildHashTree(100)
+	identity := func(hash uint64) uint64 { return hash }
+
+	var buf bytes.Buffer
+	NewFlatTree(tree, identity, nil, hammingDist).WriteFlat(&buf)
+	data := buf.Bytes()
+	// a flipped hash keeps the structure of the snapshot valid
+	data[flatHeaderSize+3] ^= 0xff
+	path := filepath.Join(t.TempDir(), "tree.flat")
+	os.WriteFile(path, data, 0o644)
+
+	// act
+	_, err := OpenFlat(path, hammingDist)
+	flat, errUnverified := OpenFlatUnverified(path, hammingDist)
+
+	// assert
+	if err == nil {
+		t.Errorf("OpenFlat() accepted a corrupted snapshot")
+	}
+	if errUnverified != nil {
+		t.Fatalf("OpenFlatUnverified() = %v", errUnverified)
+	}
+	defer flat.Close()
+	if flat.Verify() {
+		t.Errorf("Verify() = true, want false")
+	}
+}
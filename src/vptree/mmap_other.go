@@ -0,0 +1,18 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd)
+
+package vptree
+
+import (
+	"io"
+	"os"
+)
+
+// mapFile reads the whole file in memory on the platforms
+// where memory-mapping is not supported
+func mapFile(file *os.File) ([]byte, func([]byte) error, error) {
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, func([]byte) error { return nil }, nil
+}
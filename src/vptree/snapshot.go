@@ -0,0 +1,233 @@
+package vptree
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"hash/crc32"
+	"io"
+	"math"
+)
+
+// Layout of a snapshot, all integers are little endian:
+//
+//	magic    [4]byte  "VPTS"
+//	version  uint16
+//	count    uint64   number of nodes
+//	nodes    count times, in pre-order:
+//	           flags    uint8 (hasLeft, hasRight, deleted)
+//	           bounds   4 float64 (LeftMin, LeftMax, RightMin, RightMax)
+//	           length   uvarint
+//	           point    [length]byte
+//	checksum uint32   CRC-32 (IEEE) of everything above
+const (
+	snapshotMagic   = "VPTS"
+	snapshotVersion = 1
+	// upper bound on the size of a serialized vantage point, so that
+	// a corrupted length does not trigger a huge allocation
+	maxPointSize = 1 << 20
+)
+
+const (
+	flagLeft uint8 = 1 << iota
+	flagRight
+	flagDeleted
+)
+
+// PointEncoder serializes a vantage point of a VPTree
+type PointEncoder[T comparable] func(point T) ([]byte, error)
+
+// PointDecoder deserializes a vantage point written by a PointEncoder
+type PointDecoder[T comparable] func(data []byte) (T, error)
+
+// ErrInvalidSnapshot is returned when reading a snapshot that is
+// corrupted or was not written by WriteSnapshot
+var ErrInvalidSnapshot = errors.New("Invalid VP-Tree snapshot")
+
+// WriteSnapshot writes the structure of the VP-Tree to `w`, using
+// `encode` for serializing the vantage points
+func (tree *VPTree[T]) WriteSnapshot(w io.Writer, encode PointEncoder[T]) error {
+	tree.mu.RLock()
+	defer tree.mu.RUnlock()
+
+	checksum := crc32.NewIEEE()
+	bw := bufio.NewWriter(io.MultiWriter(w, checksum))
+
+	bw.WriteString(snapshotMagic)
+	binary.Write(bw, binary.LittleEndian, uint16(snapshotVersion))
+	binary.Write(bw, binary.LittleEndian, uint64(tree.Root.subtreeSize()))
+
+	buf := make([]byte, 1+4*8+binary.MaxVarintLen64)
+
+	var walk func(*VPNode[T]) error
+	walk = func(node *VPNode[T]) error {
+		var flags uint8
+		if node.Left != nil {
+			flags |= flagLeft
+		}
+		if node.Right != nil {
+			flags |= flagRight
+		}
+		if node.Deleted {
+			flags |= flagDeleted
+		}
+
+		data, err := encode(node.VantagePoint)
+		if err != nil {
+			return err
+		}
+
+		buf[0] = flags
+		binary.LittleEndian.PutUint64(buf[1:], math.Float64bits(node.LeftMin))
+		binary.LittleEndian.PutUint64(buf[9:], math.Float64bits(node.LeftMax))
+		binary.LittleEndian.PutUint64(buf[17:], math.Float64bits(node.RightMin))
+		binary.LittleEndian.PutUint64(buf[25:], math.Float64bits(node.RightMax))
+		n := binary.PutUvarint(buf[33:], uint64(len(data)))
+
+		if _, err := bw.Write(buf[:33+n]); err != nil {
+			return err
+		}
+		if _, err := bw.Write(data); err != nil {
+			return err
+		}
+
+		if node.Left != nil {
+			if err := walk(node.Left); err != nil {
+				return err
+			}
+		}
+		if node.Right != nil {
+			return walk(node.Right)
+		}
+		return nil
+	}
+
+	if tree.Root != nil {
+		if err := walk(tree.Root); err != nil {
+			return err
+		}
+	}
+
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	return binary.Write(w, binary.LittleEndian, checksum.Sum32())
+}
+
+type snapshotReader struct {
+	r        *bufio.Reader
+	checksum hash.Hash32
+}
+
+func (sr *snapshotReader) ReadByte() (byte, error) {
+	b, err := sr.r.ReadByte()
+	if err == nil {
+		sr.checksum.Write([]byte{b})
+	}
+	return b, err
+}
+
+func (sr *snapshotReader) readFull(data []byte) error {
+	if _, err := io.ReadFull(sr.r, data); err != nil {
+		return err
+	}
+	sr.checksum.Write(data)
+	return nil
+}
+
+// ReadSnapshot restores a VP-Tree written by WriteSnapshot, using
+// `decode` for deserializing the vantage points. The distances are
+// not recomputed, `distanceFnc` must be the function the tree
+// was built with.
+func ReadSnapshot[T comparable](r io.Reader, distanceFnc DistanceFnc[T], decode PointDecoder[T]) (*VPTree[T], error) {
+	sr := &snapshotReader{r: bufio.NewReader(r), checksum: crc32.NewIEEE()}
+
+	header := make([]byte, len(snapshotMagic)+2+8)
+	if err := sr.readFull(header); err != nil {
+		return nil, ErrInvalidSnapshot
+	}
+	if string(header[:len(snapshotMagic)]) != snapshotMagic {
+		return nil, ErrInvalidSnapshot
+	}
+	if version := binary.LittleEndian.Uint16(header[len(snapshotMagic):]); version != snapshotVersion {
+		return nil, errors.New("Unsupported VP-Tree snapshot version")
+	}
+	count := binary.LittleEndian.Uint64(header[len(snapshotMagic)+2:])
+
+	var read uint64
+	fields := make([]byte, 1+4*8)
+
+	var readNode func() (*VPNode[T], error)
+	readNode = func() (*VPNode[T], error) {
+		if read == count {
+			return nil, ErrInvalidSnapshot
+		}
+		read++
+
+		if err := sr.readFull(fields); err != nil {
+			return nil, ErrInvalidSnapshot
+		}
+		flags := fields[0]
+		node := new(VPNode[T])
+		node.LeftMin = math.Float64frombits(binary.LittleEndian.Uint64(fields[1:]))
+		node.LeftMax = math.Float64frombits(binary.LittleEndian.Uint64(fields[9:]))
+		node.RightMin = math.Float64frombits(binary.LittleEndian.Uint64(fields[17:]))
+		node.RightMax = math.Float64frombits(binary.LittleEndian.Uint64(fields[25:]))
+		node.Deleted = flags&flagDeleted != 0
+
+		length, err := binary.ReadUvarint(sr)
+		if err != nil || length > maxPointSize {
+			return nil, ErrInvalidSnapshot
+		}
+		data := make([]byte, length)
+		if err := sr.readFull(data); err != nil {
+			return nil, ErrInvalidSnapshot
+		}
+		if node.VantagePoint, err = decode(data); err != nil {
+			return nil, err
+		}
+
+		if flags&flagLeft != 0 {
+			if node.Left, err = readNode(); err != nil {
+				return nil, err
+			}
+		}
+		if flags&flagRight != 0 {
+			if node.Right, err = readNode(); err != nil {
+				return nil, err
+			}
+		}
+
+		node.size = 1 + node.Left.subtreeSize() + node.Right.subtreeSize()
+		if node.Deleted {
+			node.tombstones = 1
+		}
+		if node.Left != nil {
+			node.tombstones += node.Left.tombstones
+		}
+		if node.Right != nil {
+			node.tombstones += node.Right.tombstones
+		}
+		return node, nil
+	}
+
+	tree := &VPTree[T]{distanceFnc: distanceFnc, config: defaultBuildConfig()}
+	if count > 0 {
+		var err error
+		if tree.Root, err = readNode(); err != nil {
+			return nil, err
+		}
+	}
+	if read != count {
+		return nil, ErrInvalidSnapshot
+	}
+
+	want := sr.checksum.Sum32()
+	var got uint32
+	if err := binary.Read(sr.r, binary.LittleEndian, &got); err != nil || got != want {
+		return nil, ErrInvalidSnapshot
+	}
+
+	return tree, nil
+}
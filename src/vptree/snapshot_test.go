@@ -0,0 +1,103 @@
+package vptree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func encodeFloat(point float64) ([]byte, error) {
+	data := make([]byte, 8)
+	binary.LittleEndian.PutUint64(data, math.Float64bits(point))
+	return data, nil
+}
+
+func decodeFloat(data []byte) (float64, error) {
+	return math.Float64frombits(binary.LittleEndian.Uint64(data)), nil
+}
+
+func sameStructure(node1, node2 *VPNode[float64]) bool {
+	if node1 == nil || node2 == nil {
+		return node1 == node2
+	}
+	return node1.VantagePoint == node2.VantagePoint &&
+		node1.Deleted == node2.Deleted &&
+		node1.LeftMin == node2.LeftMin && node1.LeftMax == node2.LeftMax &&
+		node1.RightMin == node2.RightMin && node1.RightMax == node2.RightMax &&
+		node1.size == node2.size && node1.tombstones == node2.tombstones &&
+		sameStructure(node1.Left, node2.Left) && sameStructure(node1.Right, node2.Right)
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	// arrange
+	points := make([]float64, 0)
+	for i := 0; i < 200; i++ {
+		points = append(points, float64(i)/3)
+	}
+	distanceFnc := func(point1, point2 float64) float64 { return math.Abs(point1 - point2) }
+
+	tree := BuildTree(points, distanceFnc)
+	tree.Delete(12)
+	tree.Insert(1000)
+
+	// act
+	var buf bytes.Buffer
+	errW := tree.WriteSnapshot(&buf, encodeFloat)
+	got, errR := ReadSnapshot(&buf, distanceFnc, decodeFloat)
+
+	// assert
+	if errW != nil || errR != nil {
+		t.Fatalf("WriteSnapshot() = %v, ReadSnapshot() = %v", errW, errR)
+	}
+	if !sameStructure(tree.Root, got.Root) {
+		t.Errorf("restored tree differs from the original")
+	}
+
+	want, _ := tree.KNNSearch(12, 5)
+	gotKNN, _ := got.KNNSearch(12, 5)
+	if !reflect.DeepEqual(want, gotKNN) {
+		t.Errorf("want and got not equal")
+	}
+}
+
+func TestSnapshotEmptyTree(t *testing.T) {
+	// arrange
+	distanceFnc := func(point1, point2 float64) float64 { return math.Abs(point1 - point2) }
+	tree := BuildTree([]float64{}, distanceFnc)
+
+	// act
+	var buf bytes.Buffer
+	tree.WriteSnapshot(&buf, encodeFloat)
+	got, err := ReadSnapshot(&buf, distanceFnc, decodeFloat)
+
+	// assert
+	if err != nil || got.Root != nil {
+		t.Errorf("ReadSnapshot() = %v, %v, want empty tree", got.Root, err)
+	}
+}
+
+func TestSnapshotCorrupted(t *testing.T) {
+	// arrange
+	points := []float64{2.3, 4.2, 1.3, 9.3, 0.1, 1.1, 2.4}
+	distanceFnc := func(point1, point2 float64) float64 { return math.Abs(point1 - point2) }
+	tree := BuildTree(points, distanceFnc)
+
+	var buf bytes.Buffer
+	tree.WriteSnapshot(&buf, encodeFloat)
+	data := buf.Bytes()
+
+	// act
+	_, errTruncated := ReadSnapshot(bytes.NewReader(data[:len(data)-3]), distanceFnc, decodeFloat)
+	data[len(data)/2] ^= 0xff
+	_, errFlipped := ReadSnapshot(bytes.NewReader(data), distanceFnc, decodeFloat)
+
+	// assert
+	if errFlipped == nil {
+		t.Errorf("ReadSnapshot() accepted a corrupted snapshot")
+	}
+	if errTruncated == nil {
+		t.Errorf("ReadSnapshot() accepted a truncated snapshot")
+	}
+}
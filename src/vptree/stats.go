@@ -0,0 +1,73 @@
+package vptree
+
+// TreeStats describes the shape of a VP-Tree
+type TreeStats struct {
+	// Nodes is the number of nodes, including the deleted ones
+	Nodes int
+	// Deleted is the number of deleted nodes
+	Deleted int
+	// Leaves is the number of nodes without children
+	Leaves int
+	// MaxDepth is the depth of the deepest leaf, the root being at depth 1
+	MaxDepth int
+	// AvgDepth is the average depth of the nodes
+	AvgDepth float64
+	// Balance is the average ratio between the sizes of the smaller and
+	// the larger child of the nodes which have children. It is 1 for a
+	// perfectly balanced tree and goes towards 0 as the tree degenerates.
+	Balance float64
+}
+
+// Stats walks the VP-Tree and returns statistics on its shape
+func (tree *VPTree[T]) Stats() TreeStats {
+	tree.mu.RLock()
+	defer tree.mu.RUnlock()
+
+	var stats TreeStats
+	totalDepth := 0
+	internalNodes := 0
+	totalBalance := 0.
+
+	var walk func(*VPNode[T], int)
+	walk = func(node *VPNode[T], depth int) {
+		stats.Nodes++
+		totalDepth += depth
+		if node.Deleted {
+			stats.Deleted++
+		}
+
+		if node.Left == nil && node.Right == nil {
+			stats.Leaves++
+			if depth > stats.MaxDepth {
+				stats.MaxDepth = depth
+			}
+			return
+		}
+
+		left, right := node.Left.subtreeSize(), node.Right.subtreeSize()
+		if left > right {
+			left, right = right, left
+		}
+		internalNodes++
+		totalBalance += float64(left) / float64(right)
+
+		if node.Left != nil {
+			walk(node.Left, depth+1)
+		}
+		if node.Right != nil {
+			walk(node.Right, depth+1)
+		}
+	}
+
+	if tree.Root == nil {
+		return stats
+	}
+	walk(tree.Root, 1)
+
+	stats.AvgDepth = float64(totalDepth) / float64(stats.Nodes)
+	stats.Balance = 1
+	if internalNodes > 0 {
+		stats.Balance = totalBalance / float64(internalNodes)
+	}
+	return stats
+}
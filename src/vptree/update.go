@@ -0,0 +1,213 @@
+package vptree
+
+import "math"
+
+const (
+	// subtrees smaller than this are never rebuilt
+	minRebuildSize = 16
+	// a subtree is rebuilt when one of its children holds more
+	// than this fraction of its nodes
+	maxChildFraction = 0.75
+	// a subtree is rebuilt when more than this fraction of its
+	// nodes are tombstones
+	maxTombstoneFraction = 0.5
+)
+
+func (node *VPNode[T]) subtreeSize() int {
+	if node == nil {
+		return 0
+	}
+	return node.size
+}
+
+func (node *VPNode[T]) unbalanced() bool {
+	if node.size < minRebuildSize {
+		return false
+	}
+	limit := maxChildFraction * float64(node.size)
+	if float64(node.Left.subtreeSize()) > limit || float64(node.Right.subtreeSize()) > limit {
+		return true
+	}
+	return float64(node.tombstones) > maxTombstoneFraction*float64(node.size)
+}
+
+// livePoints returns the vantage points of the subtree
+// rooted at `node` which have not been deleted
+func (node *VPNode[T]) livePoints() []T {
+	points := make([]T, 0, node.subtreeSize()-node.tombstones)
+
+	var walk func(*VPNode[T])
+	walk = func(n *VPNode[T]) {
+		if n == nil {
+			return
+		}
+		if !n.Deleted {
+			points = append(points, n.VantagePoint)
+		}
+		walk(n.Left)
+		walk(n.Right)
+	}
+	walk(node)
+
+	return points
+}
+
+// rebuild replaces the highest unbalanced node of `path` by a
+// freshly built subtree holding the same live points. The bounds
+// of the ancestors stay valid since no live point is moved out
+// of the subtree.
+func (tree *VPTree[T]) rebuild(path []*VPNode[T]) {
+	for i, node := range path {
+		if !node.unbalanced() {
+			continue
+		}
+
+		removed := node.size
+		tombstones := node.tombstones
+		subtree := newBuilder(tree.distanceFnc, tree.config).build(node.livePoints())
+
+		if i == 0 {
+			tree.Root = subtree
+		} else if path[i-1].Left == node {
+			path[i-1].Left = subtree
+		} else {
+			path[i-1].Right = subtree
+		}
+
+		for _, ancestor := range path[:i] {
+			ancestor.size -= removed - subtree.subtreeSize()
+			ancestor.tombstones -= tombstones
+		}
+		return
+	}
+}
+
+// Insert adds `point` to the VP-Tree without rebuilding it. The
+// subtree along the insertion path is rebuilt when it becomes
+// too unbalanced.
+func (tree *VPTree[T]) Insert(point T) {
+	tree.mu.Lock()
+	defer tree.mu.Unlock()
+
+	if tree.Root == nil {
+		tree.Root = makeNode(point)
+		return
+	}
+
+	path := make([]*VPNode[T], 0)
+	currentNode := tree.Root
+
+	for {
+		path = append(path, currentNode)
+		currentNode.size++
+		dist := tree.distanceFnc(currentNode.VantagePoint, point)
+
+		goLeft := dist <= currentNode.LeftMax
+		if !goLeft && dist < currentNode.RightMin {
+			// the point falls between the two children, pick the
+			// one whose bounds need to grow the least
+			goLeft = dist-currentNode.LeftMax < currentNode.RightMin-dist
+		}
+
+		if goLeft {
+			currentNode.LeftMin = math.Min(dist, currentNode.LeftMin)
+			currentNode.LeftMax = math.Max(dist, currentNode.LeftMax)
+			if currentNode.Left == nil {
+				currentNode.Left = makeNode(point)
+				break
+			}
+			currentNode = currentNode.Left
+		} else {
+			currentNode.RightMin = math.Min(dist, currentNode.RightMin)
+			currentNode.RightMax = math.Max(dist, currentNode.RightMax)
+			if currentNode.Right == nil {
+				currentNode.Right = makeNode(point)
+				break
+			}
+			currentNode = currentNode.Right
+		}
+	}
+
+	tree.rebuild(path)
+}
+
+// Delete removes `point` from the VP-Tree and reports whether it
+// was found. The node is only marked as deleted, the subtree holding
+// it is rebuilt once it contains too many deleted nodes.
+func (tree *VPTree[T]) Delete(point T) bool {
+	tree.mu.Lock()
+	defer tree.mu.Unlock()
+
+	var find func(*VPNode[T], []*VPNode[T]) []*VPNode[T]
+	find = func(node *VPNode[T], path []*VPNode[T]) []*VPNode[T] {
+		if node == nil {
+			return nil
+		}
+		path = append(path, node)
+		if !node.Deleted && node.VantagePoint == point {
+			return path
+		}
+
+		// a point of a subtree is always within the bounds
+		// of that subtree
+		dist := tree.distanceFnc(node.VantagePoint, point)
+		if node.LeftMin <= dist && dist <= node.LeftMax {
+			if found := find(node.Left, path); found != nil {
+				return found
+			}
+		}
+		if node.RightMin <= dist && dist <= node.RightMax {
+			return find(node.Right, path)
+		}
+		return nil
+	}
+
+	path := find(tree.Root, make([]*VPNode[T], 0))
+	if path == nil {
+		return false
+	}
+
+	path[len(path)-1].Deleted = true
+	for _, node := range path {
+		node.tombstones++
+	}
+
+	if tree.Root.tombstones == tree.Root.size {
+		tree.Root = nil
+		return true
+	}
+
+	tree.rebuild(path)
+	return true
+}
+
+// Len returns the number of points in the VP-Tree
+// which have not been deleted
+func (tree *VPTree[T]) Len() int {
+	tree.mu.RLock()
+	defer tree.mu.RUnlock()
+
+	if tree.Root == nil {
+		return 0
+	}
+	return tree.Root.size - tree.Root.tombstones
+}
+
+// Points returns the points of the VP-Tree which have not been deleted,
+// copied under its lock, hence it is safe with concurrent updates
+func (tree *VPTree[T]) Points() []T {
+	tree.mu.RLock()
+	defer tree.mu.RUnlock()
+
+	if tree.Root == nil {
+		return nil
+	}
+	return tree.Root.livePoints()
+}
+
+// Ready reports whether the VP-Tree holds any point
+func (tree *VPTree[T]) Ready() bool {
+	tree.mu.RLock()
+	defer tree.mu.RUnlock()
+	return tree.Root != nil
+}
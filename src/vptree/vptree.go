@@ -1,40 +1,53 @@
 package vptree
 
 import (
+	"context"
 	"errors"
 	"math"
 	"math/rand"
 	"sort"
+	"sync"
 	"time"
 
 	"github.com/ef-ds/deque"
+	index "github.com/jx3yang/imgsearchengine/src/index"
 )
 
-type kvp struct {
+type kvp[V any] struct {
 	key   float64
-	value interface{}
+	value V
 }
 
-type heap struct {
-	Data []kvp
+// heap is a max-heap of kvp ordered by key, and by value
+// using tieBreak when the keys are equal
+type heap[V any] struct {
+	Data     []kvp[V]
+	tieBreak func(value1, value2 V) bool
 }
 
-func (h *heap) Root() float64 { return h.Data[0].key }
+func (h *heap[V]) Root() float64 { return h.Data[0].key }
 
-func (h *heap) Len() int { return len(h.Data) }
+func (h *heap[V]) Len() int { return len(h.Data) }
 
-func (h *heap) leftChildIdx(k int) int { return 2*k + 1 }
+func (h *heap[V]) leftChildIdx(k int) int { return 2*k + 1 }
 
-func (h *heap) rightChildIdx(k int) int { return 2*k + 2 }
+func (h *heap[V]) rightChildIdx(k int) int { return 2*k + 2 }
 
-func (h *heap) parentIdx(k int) int { return (k - 1) / 2 }
+func (h *heap[V]) parentIdx(k int) int { return (k - 1) / 2 }
 
-func (h *heap) fixUp(k int) {
+func (h *heap[V]) before(item1, item2 kvp[V]) bool {
+	if item1.key != item2.key || h.tieBreak == nil {
+		return item1.key < item2.key
+	}
+	return h.tieBreak(item1.value, item2.value)
+}
+
+func (h *heap[V]) fixUp(k int) {
 	for k > 0 {
 		parentIdx := h.parentIdx(k)
 		parentItem := h.Data[parentIdx]
 		currentItem := h.Data[k]
-		if parentItem.key < currentItem.key {
+		if h.before(parentItem, currentItem) {
 			h.swap(parentIdx, k)
 			k = parentIdx
 		} else {
@@ -43,18 +56,18 @@ func (h *heap) fixUp(k int) {
 	}
 }
 
-func (h *heap) fixDown(k int) {
+func (h *heap[V]) fixDown(k int) {
 	n := h.Len()
 	for k < n {
 		leftIdx := h.leftChildIdx(k)
 		rightIdx := h.rightChildIdx(k)
 		if leftIdx < n {
 			maxChildIdx := leftIdx
-			if rightIdx < n && h.Data[leftIdx].key < h.Data[rightIdx].key {
+			if rightIdx < n && h.before(h.Data[leftIdx], h.Data[rightIdx]) {
 				maxChildIdx = rightIdx
 			}
 
-			if h.Data[k].key < h.Data[maxChildIdx].key {
+			if h.before(h.Data[k], h.Data[maxChildIdx]) {
 				h.swap(k, maxChildIdx)
 				k = maxChildIdx
 			} else {
@@ -66,11 +79,11 @@ func (h *heap) fixDown(k int) {
 	}
 }
 
-func (h *heap) swap(i, j int) {
+func (h *heap[V]) swap(i, j int) {
 	h.Data[i], h.Data[j] = h.Data[j], h.Data[i]
 }
 
-func (h *heap) Pop() (*kvp, error) {
+func (h *heap[V]) Pop() (*kvp[V], error) {
 	if h.Len() == 0 {
 		return nil, errors.New("Heap is empty")
 	}
@@ -81,24 +94,41 @@ func (h *heap) Pop() (*kvp, error) {
 	return &target, nil
 }
 
-func (h *heap) Push(item kvp) {
+func (h *heap[V]) Push(item kvp[V]) {
 	h.Data = append(h.Data, item)
 	h.fixUp(h.Len() - 1)
 }
 
+// Offer pushes `item` if the heap holds less than `k` items, or replaces
+// the root by `item` if it comes before the root
+func (h *heap[V]) Offer(item kvp[V], k uint) {
+	if uint(h.Len()) < k {
+		h.Push(item)
+	} else if h.before(item, h.Data[0]) {
+		h.Data[0] = item
+		h.fixDown(0)
+	}
+}
+
 // VPNode is a node in a VPTree
-type VPNode struct {
-	Left         *VPNode
-	Right        *VPNode
+type VPNode[T comparable] struct {
+	Left         *VPNode[T]
+	Right        *VPNode[T]
 	LeftMin      float64
 	LeftMax      float64
 	RightMin     float64
 	RightMax     float64
-	VantagePoint interface{}
+	VantagePoint T
+	// Deleted marks a tombstoned vantage point which is still used
+	// for routing but is no longer returned by the searches
+	Deleted bool
+
+	size       int
+	tombstones int
 }
 
-func makeNode(point interface{}) *VPNode {
-	n := new(VPNode)
+func makeNode[T comparable](point T) *VPNode[T] {
+	n := new(VPNode[T])
 	n.VantagePoint = point
 	n.Left = nil
 	n.Right = nil
@@ -106,17 +136,33 @@ func makeNode(point interface{}) *VPNode {
 	n.LeftMax = 0
 	n.RightMin = math.MaxFloat64
 	n.RightMax = 0
+	n.size = 1
 	return n
 }
 
 // DistanceFnc is a function that computes the distance between two points
 // Requires: DistanceFnc(point1, point2) >= 0
-type DistanceFnc func(point1, point2 interface{}) float64
+type DistanceFnc[T comparable] func(point1, point2 T) float64
+
+var _ index.SearchIndex[int] = (*VPTree[int])(nil)
 
 // VPTree implements the Vantage Point Tree
-type VPTree struct {
-	Root        *VPNode
-	distanceFnc DistanceFnc
+type VPTree[T comparable] struct {
+	Root        *VPNode[T]
+	distanceFnc DistanceFnc[T]
+	tieBreak    func(point1, point2 T) bool
+	// config is used when rebuilding subtrees
+	config buildConfig
+	mu     sync.RWMutex
+}
+
+// SetTieBreaker sets the function ordering the points at the same distance
+// from the query. With a tie breaker, the results of the searches no longer
+// depend on the shape of the tree, which is built randomly.
+func (tree *VPTree[T]) SetTieBreaker(less func(point1, point2 T) bool) {
+	tree.mu.Lock()
+	defer tree.mu.Unlock()
+	tree.tieBreak = less
 }
 
 func kthElement(distances []float64, k int) float64 {
@@ -131,8 +177,9 @@ func kthElement(distances []float64, k int) float64 {
 		return distances[k]
 	}
 
-	// use the randomized quick select algorithm
-	rand.Seed(time.Now().UnixNano())
+	// use the randomized quick select algorithm, the generator is seeded
+	// once by BuildTree since seeding it is costly and serializes the
+	// goroutines building the tree
 	idx := rand.Intn(n)
 	distances[idx], distances[n-1] = distances[n-1], distances[idx]
 
@@ -172,10 +219,12 @@ func findMedian(distances []float64) float64 {
 	return kthElement(copySlice, len(distances)/2)
 }
 
-func buildTree(points []interface{}, distanceFnc DistanceFnc) *VPNode {
+func (b *builder[T]) build(points []T) *VPNode[T] {
 	if len(points) == 0 {
 		return nil
 	}
+	// the vantage point is moved to the end of the slice
+	b.selectVantagePoint(points)
 	currentNode := makeNode(points[len(points)-1])
 	points = points[:len(points)-1]
 
@@ -183,24 +232,38 @@ func buildTree(points []interface{}, distanceFnc DistanceFnc) *VPNode {
 		return currentNode
 	}
 
-	distances := make([]float64, len(points))
-
-	for i, point := range points {
-		distances[i] = distanceFnc(currentNode.VantagePoint, point)
-	}
+	distances := b.distances(currentNode.VantagePoint, points)
 
 	median := findMedian(distances)
 
-	var leftPoints []interface{}
-	var rightPoints []interface{}
+	// when many points are at the median distance, e.g. with discrete
+	// distances, some of them go left so that both children have the same
+	// size. The bounds of the children then overlap, which the searches
+	// handle since they only rely on the bounds.
+	below := 0
+	for _, dist := range distances {
+		if dist < median {
+			below++
+		}
+	}
+	tiesLeft := len(points)/2 - below
+
+	var leftPoints []T
+	var rightPoints []T
 
-	swapLastTwo := func(slice []interface{}) {
+	swapLastTwo := func(slice []T) {
 		lenSlice := len(slice)
 		slice[lenSlice-1], slice[lenSlice-2] = slice[lenSlice-2], slice[lenSlice-1]
 	}
 
 	for i, dist := range distances {
-		if dist >= median {
+		goLeft := dist < median
+		if dist == median && tiesLeft > 0 {
+			goLeft = true
+			tiesLeft--
+		}
+
+		if !goLeft {
 			currentNode.RightMin = math.Min(dist, currentNode.RightMin)
 			currentNode.RightMax = math.Max(dist, currentNode.RightMax)
 			rightPoints = append(rightPoints, points[i])
@@ -221,143 +284,197 @@ func buildTree(points []interface{}, distanceFnc DistanceFnc) *VPNode {
 		}
 	}
 
-	currentNode.Left = buildTree(leftPoints, distanceFnc)
-	currentNode.Right = buildTree(rightPoints, distanceFnc)
+	b.buildChildren(currentNode, leftPoints, rightPoints)
+	currentNode.size += currentNode.Left.subtreeSize() + currentNode.Right.subtreeSize()
 	return currentNode
 }
 
 // BuildTree will return the root of the VP-Tree built from
 // `points` using the `distanceFnc` for computing the distances
-func BuildTree(points []interface{}, distanceFnc DistanceFnc) *VPTree {
+func BuildTree[T comparable](points []T, distanceFnc DistanceFnc[T], opts ...BuildOption) *VPTree[T] {
+	config := defaultBuildConfig()
+	for _, opt := range opts {
+		opt(&config)
+	}
+
 	rand.Seed(time.Now().UnixNano())
 	rand.Shuffle(len(points), func(i, j int) { points[i], points[j] = points[j], points[i] })
-	root := buildTree(points, distanceFnc)
-	return &VPTree{
+	root := newBuilder(distanceFnc, config).build(points)
+	return &VPTree[T]{
 		Root:        root,
 		distanceFnc: distanceFnc,
+		config:      config,
 	}
 }
 
-// KNNSearch will return the k nearest neighbours of the given `point`
-// in the VP-Tree
-func (tree *VPTree) KNNSearch(point interface{}, k uint) (map[interface{}]float64, error) {
-	if k < 1 {
-		return nil, errors.New("Invalid k")
+// SearchOptions bounds the work done by a search, a zero field means no limit
+type SearchOptions struct {
+	// MaxNodes is the maximum number of nodes visited by the search
+	MaxNodes int
+	// MaxResults is the maximum number of points returned by a range search
+	MaxResults int
+	// MaxLeaves is the maximum number of leaves visited by the search
+	MaxLeaves int
+	// Epsilon makes a KNN search approximate: the subtrees which cannot hold
+	// a point closer than tau / (1 + Epsilon) are skipped, where tau is the
+	// distance of the current kth neighbour. Each neighbour found is then at
+	// most (1 + Epsilon) times further than the exact one.
+	Epsilon float64
+}
+
+// pushChildren queues the children of `node` which may contain points
+// within `tau` of the query, given the distance `dist` between the
+// query and the vantage point of `node`
+func pushChildren[T comparable](nodesToVisit *deque.Deque, node *VPNode[T], dist float64, tau float64) {
+	if node.LeftMin <= dist && dist <= node.LeftMax {
+		nodesToVisit.PushFront(kvp[*VPNode[T]]{0, node.Left})
+	} else if node.LeftMin-tau <= dist && dist <= node.LeftMax+tau {
+		if dist < node.LeftMin {
+			nodesToVisit.PushBack(kvp[*VPNode[T]]{node.LeftMin - dist, node.Left})
+		} else {
+			nodesToVisit.PushBack(kvp[*VPNode[T]]{dist - node.LeftMax, node.Left})
+		}
 	}
-	root := tree.Root
-	nodesToVisit := deque.New()
-	nodesToVisit.PushFront(kvp{0, root})
 
-	tau := math.MaxFloat64
+	if node.RightMin <= dist && dist <= node.RightMax {
+		nodesToVisit.PushFront(kvp[*VPNode[T]]{0, node.Right})
+	} else if node.RightMin-tau <= dist && dist <= node.RightMax+tau {
+		if dist < node.RightMin {
+			nodesToVisit.PushBack(kvp[*VPNode[T]]{node.RightMin - dist, node.Right})
+		} else {
+			nodesToVisit.PushBack(kvp[*VPNode[T]]{dist - node.RightMax, node.Right})
+		}
+	}
+}
 
-	results := new(heap)
+// search visits the nodes which may contain points within `radius()` of
+// `point`, calling `found` for every point visited until it returns false.
+// It returns the number of nodes visited, and reports whether the search
+// stopped before visiting all those nodes.
+func (tree *VPTree[T]) search(ctx context.Context, point T, opts SearchOptions, radius func() float64, found func(T, float64) bool) (int, bool, error) {
+	tree.mu.RLock()
+	defer tree.mu.RUnlock()
 
-	resultsLen := func() uint { return uint(results.Len()) }
+	done := ctx.Done()
+	visited := 0
+	leaves := 0
+
+	nodesToVisit := deque.New()
+	nodesToVisit.PushFront(kvp[*VPNode[T]]{0, tree.Root})
 
 	for nodesToVisit.Len() > 0 {
 		pair, _ := nodesToVisit.PopFront()
-		kvpObj := pair.(kvp)
-		d0, currentNode := kvpObj.key, kvpObj.value.(*VPNode)
-		if currentNode == nil || d0 > tau {
+		kvpObj := pair.(kvp[*VPNode[T]])
+		d0, currentNode := kvpObj.key, kvpObj.value
+		if currentNode == nil || d0 > radius() {
 			continue
 		}
-		dist := tree.distanceFnc(point, currentNode.VantagePoint)
 
-		if dist < tau {
-			if resultsLen() == k {
-				results.Pop()
-			}
-			results.Push(kvp{dist, currentNode.VantagePoint})
-			if resultsLen() == k {
-				tau = results.Root()
-			}
+		select {
+		case <-done:
+			return visited, true, ctx.Err()
+		default:
 		}
-
-		if currentNode.Left == nil && currentNode.Right == nil {
-			continue
+		if opts.MaxNodes > 0 && visited == opts.MaxNodes {
+			return visited, true, nil
 		}
+		visited++
 
-		if currentNode.LeftMin <= dist && dist <= currentNode.LeftMax {
-			nodesToVisit.PushFront(kvp{0, currentNode.Left})
-		} else if currentNode.LeftMin-tau <= dist && dist <= currentNode.LeftMax+tau {
-			if dist < currentNode.LeftMin {
-				nodesToVisit.PushBack(kvp{currentNode.LeftMin - dist, currentNode.Left})
-			} else {
-				nodesToVisit.PushBack(kvp{dist - currentNode.LeftMax, currentNode.Left})
-			}
+		dist := tree.distanceFnc(point, currentNode.VantagePoint)
+		if !currentNode.Deleted && !found(currentNode.VantagePoint, dist) {
+			return visited, true, nil
 		}
 
-		if currentNode.RightMin <= dist && dist <= currentNode.RightMax {
-			nodesToVisit.PushFront(kvp{0, currentNode.Right})
-		} else if currentNode.RightMin-tau <= dist && dist <= currentNode.RightMax+tau {
-			if dist < currentNode.RightMin {
-				nodesToVisit.PushBack(kvp{currentNode.RightMin - dist, currentNode.Right})
-			} else {
-				nodesToVisit.PushBack(kvp{dist - currentNode.RightMax, currentNode.Right})
+		if currentNode.Left == nil && currentNode.Right == nil {
+			leaves++
+			if opts.MaxLeaves > 0 && leaves == opts.MaxLeaves && nodesToVisit.Len() > 0 {
+				return visited, true, nil
 			}
+			continue
 		}
+
+		pushChildren(nodesToVisit, currentNode, dist, radius())
 	}
 
-	knnMap := make(map[interface{}]float64)
+	return visited, false, nil
+}
 
-	for _, pair := range results.Data {
-		knnMap[pair.value] = pair.key
-	}
+// KNNSearch will return the k nearest neighbours of the given `point`
+// in the VP-Tree, sorted by ascending distance
+func (tree *VPTree[T]) KNNSearch(point T, k uint) ([]index.Result[T], error) {
+	results, _, err := tree.KNNSearchContext(context.Background(), point, k, SearchOptions{})
+	return results, err
+}
 
-	return knnMap, nil
+// KNNSearchContext is KNNSearch stopping when `ctx` is done or when the
+// budget of `opts` is exhausted. It then returns the nearest neighbours
+// found so far and reports that the results are partial. The search is
+// approximate when `opts.Epsilon` is positive.
+func (tree *VPTree[T]) KNNSearchContext(ctx context.Context, point T, k uint, opts SearchOptions) ([]index.Result[T], bool, error) {
+	results, _, partial, err := tree.knnSearch(ctx, point, k, opts)
+	return results, partial, err
 }
 
-// RangeSearch will return all the points within a `threshold`
-// distance from the given `point`
-func (tree *VPTree) RangeSearch(point interface{}, threshold float64) (map[interface{}]float64, error) {
-	if threshold < 0 {
-		return nil, errors.New("Threshold must be positive")
+// knnSearch is KNNSearchContext also returning the number of nodes visited
+func (tree *VPTree[T]) knnSearch(ctx context.Context, point T, k uint, opts SearchOptions) ([]index.Result[T], int, bool, error) {
+	if k < 1 {
+		return nil, 0, false, errors.New("Invalid k")
 	}
 
-	root := tree.Root
-	nodesToVisit := deque.New()
-	nodesToVisit.PushFront(kvp{0, root})
+	tau := math.MaxFloat64
 
-	rangeMap := make(map[interface{}]float64)
+	results := &heap[T]{tieBreak: tree.tieBreak}
 
-	for nodesToVisit.Len() > 0 {
-		pair, _ := nodesToVisit.PopFront()
-		kvpObj := pair.(kvp)
-		d0, currentNode := kvpObj.key, kvpObj.value.(*VPNode)
-		if currentNode == nil || d0 > threshold {
-			continue
-		}
+	radius := func() float64 { return tau / (1 + opts.Epsilon) }
 
-		dist := tree.distanceFnc(point, currentNode.VantagePoint)
-		if dist <= threshold {
-			rangeMap[currentNode.VantagePoint] = dist
+	visited, partial, err := tree.search(ctx, point, opts, radius, func(vantagePoint T, dist float64) bool {
+		if dist <= tau {
+			results.Offer(kvp[T]{dist, vantagePoint}, k)
+			if uint(results.Len()) == k {
+				tau = results.Root()
+			}
 		}
+		return true
+	})
 
-		if currentNode.Left == nil && currentNode.Right == nil {
-			continue
-		}
+	knnResults := make([]index.Result[T], 0, results.Len())
 
-		if currentNode.LeftMin <= dist && dist <= currentNode.LeftMax {
-			nodesToVisit.PushFront(kvp{0, currentNode.Left})
-		} else if currentNode.LeftMin-threshold <= dist && dist <= currentNode.LeftMax+threshold {
-			if dist < currentNode.LeftMin {
-				nodesToVisit.PushBack(kvp{currentNode.LeftMin - dist, currentNode.Left})
-			} else {
-				nodesToVisit.PushBack(kvp{dist - currentNode.LeftMax, currentNode.Left})
-			}
-		}
+	for _, pair := range results.Data {
+		knnResults = append(knnResults, index.Result[T]{Point: pair.value, Distance: pair.key})
+	}
+	index.SortResults(knnResults, tree.tieBreak)
 
-		if currentNode.RightMin <= dist && dist <= currentNode.RightMax {
-			nodesToVisit.PushFront(kvp{0, currentNode.Right})
-		} else if currentNode.RightMin-threshold <= dist && dist <= currentNode.RightMax+threshold {
-			if dist < currentNode.RightMin {
-				nodesToVisit.PushBack(kvp{currentNode.RightMin - dist, currentNode.Right})
-			} else {
-				nodesToVisit.PushBack(kvp{dist - currentNode.RightMax, currentNode.Right})
-			}
-		}
+	return knnResults, visited, partial, err
+}
+
+// RangeSearch will return all the points within a `threshold`
+// distance from the given `point`, sorted by ascending distance
+func (tree *VPTree[T]) RangeSearch(point T, threshold float64) ([]index.Result[T], error) {
+	results, _, err := tree.RangeSearchContext(context.Background(), point, threshold, SearchOptions{})
+	return results, err
+}
 
+// RangeSearchContext is RangeSearch stopping when `ctx` is done or when
+// the budget of `opts` is exhausted. It then returns the points found
+// so far and reports that the results are partial.
+func (tree *VPTree[T]) RangeSearchContext(ctx context.Context, point T, threshold float64, opts SearchOptions) ([]index.Result[T], bool, error) {
+	if threshold < 0 {
+		return nil, false, errors.New("Threshold must be positive")
 	}
 
-	return rangeMap, nil
+	rangeResults := make([]index.Result[T], 0)
+
+	_, partial, err := tree.search(ctx, point, opts, func() float64 { return threshold }, func(vantagePoint T, dist float64) bool {
+		if dist > threshold {
+			return true
+		}
+		if opts.MaxResults > 0 && len(rangeResults) == opts.MaxResults {
+			return false
+		}
+		rangeResults = append(rangeResults, index.Result[T]{Point: vantagePoint, Distance: dist})
+		return true
+	})
+	index.SortResults(rangeResults, tree.tieBreak)
+
+	return rangeResults, partial, err
 }
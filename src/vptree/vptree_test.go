@@ -1,9 +1,17 @@
 package vptree
 
 import (
+	"context"
 	"math"
+	"math/rand"
 	"reflect"
+	"sort"
+	"strconv"
 	"testing"
+
+	index "github.com/jx3yang/imgsearchengine/src/index"
+	hashtest "github.com/jx3yang/imgsearchengine/src/internal/hashtest"
+	phash "github.com/jx3yang/imgsearchengine/src/phash"
 )
 
 func TestMedian(t *testing.T) {
@@ -31,18 +39,18 @@ func TestMedian(t *testing.T) {
 
 func TestKNNSearch(t *testing.T) {
 	// arrange
-	points := make([]interface{}, 0)
+	points := make([]float64, 0)
 	points = append(points, 2.3, 4.2, 1.3, 9.3, 0.1, 1.1, 2.4)
-	distanceFnc := func(point1, point2 interface{}) float64 { return math.Abs(point1.(float64) - point2.(float64)) }
+	distanceFnc := func(point1, point2 float64) float64 { return math.Abs(point1 - point2) }
 
 	point := 3.
 	k := 3
 
-	want := make(map[interface{}]float64)
-
-	want[2.3] = distanceFnc(point, 2.3)
-	want[4.2] = distanceFnc(point, 4.2)
-	want[2.4] = distanceFnc(point, 2.4)
+	want := []index.Result[float64]{
+		{Point: 2.4, Distance: distanceFnc(point, 2.4)},
+		{Point: 2.3, Distance: distanceFnc(point, 2.3)},
+		{Point: 4.2, Distance: distanceFnc(point, 4.2)},
+	}
 
 	// act
 	node := BuildTree(points, distanceFnc)
@@ -58,23 +66,373 @@ func TestKNNSearch(t *testing.T) {
 
 func TestRangeSearch(t *testing.T) {
 	// arrange
-	points := make([]interface{}, 0)
+	points := make([]float64, 0)
 	points = append(points, 2.3, 4.2, 1.3, 9.3, 0.1, 1.1, 2.4)
-	distanceFnc := func(point1, point2 interface{}) float64 { return math.Abs(point1.(float64) - point2.(float64)) }
+	distanceFnc := func(point1, point2 float64) float64 { return math.Abs(point1 - point2) }
 
 	point := 3.
 	threshold := 3.
 
-	want := make(map[interface{}]float64)
+	want := []index.Result[float64]{
+		{Point: 2.4, Distance: distanceFnc(point, 2.4)},
+		{Point: 2.3, Distance: distanceFnc(point, 2.3)},
+		{Point: 4.2, Distance: distanceFnc(point, 4.2)},
+		{Point: 1.3, Distance: distanceFnc(point, 1.3)},
+		{Point: 1.1, Distance: distanceFnc(point, 1.1)},
+		{Point: 0.1, Distance: distanceFnc(point, 0.1)},
+	}
+
+	node := BuildTree(points, distanceFnc)
+	got, _ := node.RangeSearch(point, threshold)
+
+	// assert
+	eq := reflect.DeepEqual(want, got)
+
+	if !eq {
+		t.Errorf("want and got not equal")
+	}
+}
+
+func TestKNNSearchTies(t *testing.T) {
+	// arrange
+	points := make([]float64, 0)
+	for i := 0; i < 100; i++ {
+		points = append(points, float64(i%10))
+	}
+	distanceFnc := func(point1, point2 float64) float64 { return math.Abs(point1 - point2) }
+	lessFnc := func(point1, point2 float64) bool { return point1 < point2 }
+
+	point := 5.
+	k := 12
+
+	// the 4s and 6s are all at distance 1, the tie breaker keeps the 4s first
+	want := make([]index.Result[float64], 0)
+	for i := 0; i < 10; i++ {
+		want = append(want, index.Result[float64]{Point: 4, Distance: 1})
+	}
+	want = append(want, index.Result[float64]{Point: 6, Distance: 1}, index.Result[float64]{Point: 6, Distance: 1})
+
+	// act
+	node := BuildTree(points, distanceFnc)
+	node.SetTieBreaker(lessFnc)
+	for i := 0; i < 10; i++ {
+		node.Delete(point)
+	}
+	got, _ := node.KNNSearch(point, uint(k))
+
+	// assert
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("KNNSearch() = %v, want %v", got, want)
+	}
+}
+
+func TestSearchBudget(t *testing.T) {
+	// arrange
+	points := make([]float64, 0)
+	for i := 0; i < 1000; i++ {
+		points = append(points, float64(i))
+	}
+	distanceFnc := func(point1, point2 float64) float64 { return math.Abs(point1 - point2) }
+
+	point := 500.
+	node := BuildTree(points, distanceFnc)
+
+	// act
+	knn, knnPartial, knnErr := node.KNNSearchContext(context.Background(), point, 10, SearchOptions{MaxNodes: 5})
+	full, fullPartial, _ := node.KNNSearchContext(context.Background(), point, 10, SearchOptions{MaxNodes: 1000})
+	rng, rngPartial, _ := node.RangeSearchContext(context.Background(), point, 100, SearchOptions{MaxResults: 20})
+
+	// assert
+	if knnErr != nil || !knnPartial || len(knn) > 5 {
+		t.Errorf("KNNSearchContext() = %v, %v, %v, want at most 5 partial results", knn, knnPartial, knnErr)
+	}
+	if fullPartial || len(full) != 10 {
+		t.Errorf("KNNSearchContext() = %v, %v, want 10 complete results", full, fullPartial)
+	}
+	if !rngPartial || len(rng) != 20 {
+		t.Errorf("RangeSearchContext() returned %d results, partial = %v, want 20 partial results", len(rng), rngPartial)
+	}
+}
+
+func TestSearchCancelled(t *testing.T) {
+	// arrange
+	points := []float64{2.3, 4.2, 1.3, 9.3, 0.1, 1.1, 2.4}
+	distanceFnc := func(point1, point2 float64) float64 { return math.Abs(point1 - point2) }
+
+	node := BuildTree(points, distanceFnc)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// act
+	got, partial, err := node.RangeSearchContext(ctx, 3, 3, SearchOptions{})
+
+	// assert
+	if err != context.Canceled || !partial || len(got) != 0 {
+		t.Errorf("RangeSearchContext() = %v, %v, %v, want cancelled search", got, partial, err)
+	}
+}
+
+// nearQueries returns hashes close to random `points`
+func nearQueries(points []phash.PHash, n int, r *rand.Rand) []phash.PHash {
+	queries := make([]phash.PHash, n)
+	for i := range queries {
+		queries[i] = points[r.Intn(len(points))] ^ 1<<uint(r.Intn(64)) ^ 1<<uint(r.Intn(64))
+	}
+	return queries
+}
+
+// bruteForceKNN returns the distance of the kth nearest neighbour of `point`
+func bruteForceKNN(points []phash.PHash, point phash.PHash, k int) float64 {
+	distances := make([]float64, len(points))
+	for i, p := range points {
+		distances[i] = phash.NormHammingDist(point, p)
+	}
+	sort.Float64s(distances)
+	return distances[k-1]
+}
+
+func TestSearchMatchesLinear(t *testing.T) {
+	// arrange
+	r := rand.New(rand.NewSource(4))
+	points := hashtest.ClusteredHashes(5000, r)
+	queries := nearQueries(points, 50, r)
+
+	tree := BuildTree(append([]phash.PHash(nil), points...), phash.NormHammingDist)
+	tree.SetTieBreaker(hashtest.Less)
+	reference := hashtest.Reference(points)
+
+	for _, query := range queries {
+		// act
+		gotKNN, _ := tree.KNNSearch(query, 10)
+		gotRange, _ := tree.RangeSearch(query, 0.1)
+
+		// assert
+		wantKNN, _ := reference.KNNSearch(query, 10)
+		wantRange, _ := reference.RangeSearch(query, 0.1)
+		if !reflect.DeepEqual(wantKNN, gotKNN) {
+			t.Errorf("KNNSearch() = %v, want %v", gotKNN, wantKNN)
+		}
+		if !reflect.DeepEqual(wantRange, gotRange) {
+			t.Errorf("RangeSearch() = %v, want %v", gotRange, wantRange)
+		}
+	}
+}
+
+func TestKNNSearchApprox(t *testing.T) {
+	// arrange
+	r := rand.New(rand.NewSource(3))
+	points := hashtest.ClusteredHashes(5000, r)
+	queries := nearQueries(points, 50, r)
+	k := 10
+	epsilon := 0.5
+
+	tree := BuildTree(append([]phash.PHash(nil), points...), phash.NormHammingDist)
+
+	for _, query := range queries {
+		// act
+		got, _, err := tree.KNNSearchContext(context.Background(), query, uint(k), SearchOptions{Epsilon: epsilon})
+
+		// assert
+		want := bruteForceKNN(points, query, k)
+		if err != nil || len(got) != k {
+			t.Fatalf("KNNSearchContext() returned %d results, %v, want %d", len(got), err, k)
+		}
+		if got[k-1].Distance > (1+epsilon)*want {
+			t.Errorf("kth neighbour at %f, want at most %f", got[k-1].Distance, (1+epsilon)*want)
+		}
+	}
+}
+
+// BenchmarkKNNSearchApprox reports the recall of the approximate KNN
+// searches, i.e. the fraction of the neighbours found which are not
+// further than the exact kth neighbour
+func BenchmarkKNNSearchApprox(b *testing.B) {
+	r := rand.New(rand.NewSource(1))
+	points := hashtest.ClusteredHashes(100000, r)
+	queries := nearQueries(points, 200, r)
+	k := 10
+
+	tree := BuildTree(append([]phash.PHash(nil), points...), phash.NormHammingDist)
+
+	kth := make([]float64, len(queries))
+	for i, query := range queries {
+		kth[i] = bruteForceKNN(points, query, k)
+	}
+
+	b.Run("bruteforce", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			bruteForceKNN(points, queries[i%len(queries)], k)
+		}
+		b.ReportMetric(1, "recall")
+	})
+
+	benchmarks := []struct {
+		name string
+		opts SearchOptions
+	}{
+		{"exact", SearchOptions{}},
+		{"epsilon=0.25", SearchOptions{Epsilon: 0.25}},
+		{"epsilon=1", SearchOptions{Epsilon: 1}},
+		{"leaves=256", SearchOptions{MaxLeaves: 256}},
+		{"leaves=32", SearchOptions{MaxLeaves: 32}},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			found, total := 0, 0
+			for i := 0; i < b.N; i++ {
+				q := i % len(queries)
+				results, _, _ := tree.KNNSearchContext(context.Background(), queries[q], uint(k), bm.opts)
+				for _, result := range results {
+					if result.Distance <= kth[q] {
+						found++
+					}
+				}
+				total += k
+			}
+			b.ReportMetric(float64(found)/float64(total), "recall")
+		})
+	}
+}
+
+func TestBuildTreeParallel(t *testing.T) {
+	// arrange
+	r := rand.New(rand.NewSource(5))
+	points := hashtest.ClusteredHashes(50000, r)
+	queries := nearQueries(points, 20, r)
+	k := 10
+
+	// act
+	tree := BuildTree(append([]phash.PHash(nil), points...), phash.NormHammingDist, WithWorkers(8))
+
+	// assert
+	if tree.Len() != len(points) {
+		t.Errorf("Len() = %d, want %d", tree.Len(), len(points))
+	}
+	for _, query := range queries {
+		got, _ := tree.KNNSearch(query, uint(k))
+		want := bruteForceKNN(points, query, k)
+		if len(got) != k || got[k-1].Distance != want {
+			t.Errorf("kth neighbour at %f, want %f", got[k-1].Distance, want)
+		}
+	}
+}
+
+func TestBuildTreeSelection(t *testing.T) {
+	// arrange
+	r := rand.New(rand.NewSource(9))
+	points := hashtest.ClusteredHashes(5000, r)
+	queries := nearQueries(points, 20, r)
+	k := 10
+
+	for _, selection := range []Selection{FarthestPoint, RandomPoint, MaxSpread} {
+		// act
+		tree := BuildTree(append([]phash.PHash(nil), points...), phash.NormHammingDist, WithSelection(selection))
+
+		// assert
+		for _, query := range queries {
+			got, _ := tree.KNNSearch(query, uint(k))
+			want := bruteForceKNN(points, query, k)
+			if len(got) != k || got[k-1].Distance != want {
+				t.Errorf("selection %d: kth neighbour at %f, want %f", selection, got[k-1].Distance, want)
+			}
+		}
+	}
+}
+
+// BenchmarkSelection reports the number of nodes visited by a KNN search
+// in trees built with the different vantage point selections
+func BenchmarkSelection(b *testing.B) {
+	r := rand.New(rand.NewSource(1))
+	points := hashtest.ClusteredHashes(100000, r)
+	queries := nearQueries(points, 200, r)
+	k := 10
+
+	selections := []struct {
+		name      string
+		selection Selection
+	}{
+		{"farthest", FarthestPoint},
+		{"random", RandomPoint},
+		{"maxspread", MaxSpread},
+	}
+	for _, s := range selections {
+		tree := BuildTree(append([]phash.PHash(nil), points...), phash.NormHammingDist, WithSelection(s.selection))
+
+		b.Run(s.name, func(b *testing.B) {
+			visits := 0
+			for i := 0; i < b.N; i++ {
+				_, visited, _, _ := tree.knnSearch(context.Background(), queries[i%len(queries)], uint(k), SearchOptions{})
+				visits += visited
+			}
+			b.ReportMetric(float64(visits)/float64(b.N), "nodes/op")
+		})
+	}
+}
+
+func BenchmarkBuildTree(b *testing.B) {
+	r := rand.New(rand.NewSource(1))
+	points := hashtest.ClusteredHashes(200000, r)
+
+	for _, workers := range []int{1, 4, 16} {
+		b.Run("workers="+strconv.Itoa(workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				BuildTree(points, phash.NormHammingDist, WithWorkers(workers))
+			}
+		})
+	}
+}
+
+func TestBuildTreeTies(t *testing.T) {
+	// arrange
+	n := 4096
+	points := make([]float64, 0)
+	for i := 0; i < n; i++ {
+		points = append(points, float64(i%5))
+	}
+	distanceFnc := func(point1, point2 float64) float64 { return math.Abs(point1 - point2) }
+
+	// with only 5 distinct distances, most points are at the median
+	maxDepth := 2 * int(math.Ceil(math.Log2(float64(n+1))))
+
+	// act
+	node := BuildTree(points, distanceFnc)
+	stats := node.Stats()
+	got, _ := node.RangeSearch(2, 0)
 
-	want[2.3] = distanceFnc(point, 2.3)
-	want[4.2] = distanceFnc(point, 4.2)
-	want[1.3] = distanceFnc(point, 1.3)
-	want[0.1] = distanceFnc(point, 0.1)
-	want[1.1] = distanceFnc(point, 1.1)
-	want[2.4] = distanceFnc(point, 2.4)
+	// assert
+	if stats.Nodes != n || stats.MaxDepth > maxDepth {
+		t.Errorf("Stats() = %+v, want %d nodes and a depth of at most %d", stats, n, maxDepth)
+	}
+	if len(got) != n/5 {
+		t.Errorf("RangeSearch() returned %d points, want %d", len(got), n/5)
+	}
+}
+
+func TestInsert(t *testing.T) {
+	// arrange
+	points := make([]float64, 0)
+	points = append(points, 2.3, 4.2, 1.3, 9.3, 0.1, 1.1, 2.4)
+	distanceFnc := func(point1, point2 float64) float64 { return math.Abs(point1 - point2) }
 
+	point := 3.
+	threshold := 1.
+
+	want := []index.Result[float64]{
+		{Point: 2.9, Distance: distanceFnc(point, 2.9)},
+		{Point: 3.5, Distance: distanceFnc(point, 3.5)},
+		{Point: 2.4, Distance: distanceFnc(point, 2.4)},
+		{Point: 2.3, Distance: distanceFnc(point, 2.3)},
+	}
+
+	// act
 	node := BuildTree(points, distanceFnc)
+	node.Insert(3.5)
+	node.Insert(2.9)
+	node.Insert(8.8)
+	// enough points to trigger a rebuild of the subtrees
+	for i := 0; i < 100; i++ {
+		node.Insert(10 + float64(i))
+	}
 	got, _ := node.RangeSearch(point, threshold)
 
 	// assert
@@ -83,4 +441,55 @@ func TestRangeSearch(t *testing.T) {
 	if !eq {
 		t.Errorf("want and got not equal")
 	}
+	if node.Len() != len(points)+103 {
+		t.Errorf("Len() = %d, want %d", node.Len(), len(points)+103)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	// arrange
+	points := make([]float64, 0)
+	for i := 0; i < 100; i++ {
+		points = append(points, float64(i))
+	}
+	distanceFnc := func(point1, point2 float64) float64 { return math.Abs(point1 - point2) }
+
+	point := 50.
+	k := 3
+
+	want := []index.Result[float64]{
+		{Point: 50, Distance: distanceFnc(point, 50)},
+		{Point: 46, Distance: distanceFnc(point, 46)},
+		{Point: 54, Distance: distanceFnc(point, 54)},
+	}
+
+	// act
+	node := BuildTree(points, distanceFnc)
+	node.SetTieBreaker(func(point1, point2 float64) bool { return point1 < point2 })
+	for i := 47; i < 54; i++ {
+		if i != 50 && !node.Delete(float64(i)) {
+			t.Errorf("Delete(%d) did not find the point", i)
+		}
+	}
+	// deleting most of the points triggers a rebuild of the subtrees
+	for i := 60; i < 100; i++ {
+		node.Delete(float64(i))
+	}
+	got, _ := node.KNNSearch(point, uint(k))
+
+	// assert
+	eq := reflect.DeepEqual(want, got)
+
+	if !eq {
+		t.Errorf("want and got not equal")
+	}
+	if node.Delete(48) {
+		t.Errorf("Delete() found a deleted point")
+	}
+	if node.Len() != 54 {
+		t.Errorf("Len() = %d, want %d", node.Len(), 54)
+	}
+	if points := node.Points(); len(points) != 54 {
+		t.Errorf("Points() = %d points, want %d", len(points), 54)
+	}
 }